@@ -0,0 +1,385 @@
+// Command fsmgen generates typed Go code for a workflow definition file, as
+// read by fsm.LoadDefinition: State and Event constants, a constructor, and a
+// helper method for each event.
+//
+// Usage:
+//
+//	fsmgen [-o output.go] [-pkg name] [-type Name] definition.yaml
+//
+// It is meant to be run by go generate:
+//
+//	//go:generate go run github.com/tendant/simple-fsm/cmd/fsmgen document.yaml
+//
+// For a definition named document, it writes document_fsm.go declaring
+// DocumentState and DocumentEvent constants such as DocumentStateDraft and
+// DocumentEventSubmit, a DocumentFSM type embedding *fsm.Typed, and
+// NewDocumentFSM(storage, opts...). Each event gets a method, such as
+// Submit(ctx, entity, actor, opts...), that triggers it. A method that would
+// hide a method of fsm.Typed gets an Event suffix, such as ResetEvent.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"unicode"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fsmgen: ")
+
+	output := flag.String("o", "", "output file (default <definition>_fsm.go)")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name (default $GOPACKAGE, set by go generate)")
+	typeName := flag.String("type", "", "type name prefix (default the definition's name)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fsmgen [flags] definition.yaml\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + "_fsm.go"
+	}
+	if *pkg == "" {
+		log.Fatal("no package name: pass -pkg or run from go generate")
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	def, err := fsm.LoadDefinition(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", input, err)
+	}
+
+	src, err := generate(def, config{
+		Source:   filepath.Base(input),
+		Package:  *pkg,
+		TypeName: *typeName,
+	})
+	if err != nil {
+		log.Fatalf("%s: %v", input, err)
+	}
+
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// config controls the generated code
+type config struct {
+	// Source is the definition file named in the generated header
+	Source string
+	// Package is the package of the generated file
+	Package string
+	// TypeName prefixes the generated identifiers; the definition's name is
+	// used if it is empty
+	TypeName string
+}
+
+// typedMethods are the methods of *fsm.Typed and the embedded field name,
+// which event helpers must not hide
+var typedMethods = map[string]bool{
+	"Typed": true, "FSM": true, "WithStorage": true,
+	"Start": true, "Reset": true, "Trigger": true,
+	"GetState": true, "GetStatePath": true, "GetActiveStates": true, "IsInState": true, "GetStateAt": true, "CanTrigger": true,
+	"GetAvailableEvents": true, "GetNextState": true,
+	"OnEnter": true, "OnExit": true, "BeforeTransition": true, "AfterTransition": true,
+}
+
+type model struct {
+	Source      string
+	Package     string
+	Name        string
+	Type        string
+	States      []constant
+	Events      []constant
+	Initial     []string
+	Terminal    []string
+	Substates   []substates
+	Parallel    []parallel
+	Transitions []transition
+}
+
+// substates are the constants of a composite state and its substates
+type substates struct {
+	Parent   string
+	Children []string
+}
+
+// parallel are the constants of a parallel state and its regions
+type parallel struct {
+	State   string
+	Join    string
+	Regions []region
+}
+
+type region struct {
+	State   string
+	Initial string
+	Final   []string
+}
+
+type constant struct {
+	Name  string
+	Value string
+	// Method is the helper method of an event
+	Method string
+	// Doc describes the transitions of an event
+	Doc string
+}
+
+type transition struct {
+	From, To, Event string
+}
+
+// generate returns the formatted Go source for def
+func generate(def *fsm.Definition, cfg config) ([]byte, error) {
+	name := cfg.TypeName
+	if name == "" {
+		name = def.Name
+	}
+	typ := identifier(name)
+	if typ == "" || !unicode.IsLetter([]rune(typ)[0]) {
+		return nil, fmt.Errorf("cannot derive a type name from %q; pass -type", name)
+	}
+
+	m := model{
+		Source:  cfg.Source,
+		Package: cfg.Package,
+		Name:    def.Name,
+		Type:    typ,
+	}
+	if m.Name == "" {
+		m.Name = name
+	}
+
+	var errs []error
+	seen := make(map[string]string)
+	unique := func(ident, kind, value string) {
+		if prev, ok := seen[ident]; ok {
+			errs = append(errs, fmt.Errorf("%s %q and %s both generate %s", kind, value, prev, ident))
+		}
+		seen[ident] = fmt.Sprintf("%s %q", kind, value)
+	}
+
+	stateConst := make(map[string]string)
+	for _, s := range def.States {
+		c := constant{Name: typ + "State" + identifier(s.Name), Value: s.Name}
+		unique(c.Name, "state", s.Name)
+		stateConst[s.Name] = c.Name
+		m.States = append(m.States, c)
+	}
+
+	eventConst := make(map[string]string)
+	for _, e := range def.Events {
+		c := constant{Name: typ + "Event" + identifier(e.Name), Value: e.Name}
+		unique(c.Name, "event", e.Name)
+		eventConst[e.Name] = c.Name
+
+		c.Method = identifier(e.Name)
+		if c.Method == "" || !unicode.IsLetter([]rune(c.Method)[0]) {
+			errs = append(errs, fmt.Errorf("event %q does not start with a letter, so it cannot name a method", e.Name))
+			continue
+		}
+		if typedMethods[c.Method] {
+			c.Method += "Event"
+		}
+		unique("method "+c.Method, "event", e.Name)
+
+		var moves []string
+		for _, t := range def.Transitions {
+			if t.Event.Name == e.Name {
+				moves = append(moves, t.From.Name+" -> "+t.To.Name)
+			}
+		}
+		if len(moves) > 0 {
+			c.Doc = " (" + strings.Join(moves, ", ") + ")"
+		}
+		m.Events = append(m.Events, c)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, s := range def.States {
+		if s.Parent == "" {
+			continue
+		}
+		i := slices.IndexFunc(m.Substates, func(sub substates) bool { return sub.Parent == stateConst[s.Parent] })
+		if i < 0 {
+			i = len(m.Substates)
+			m.Substates = append(m.Substates, substates{Parent: stateConst[s.Parent]})
+		}
+		m.Substates[i].Children = append(m.Substates[i].Children, stateConst[s.Name])
+	}
+	for _, p := range def.Parallel {
+		ps := parallel{State: stateConst[p.State.Name], Join: `""`}
+		if p.Join.Name != "" {
+			ps.Join = stateConst[p.Join.Name]
+		}
+		for _, r := range p.Regions {
+			reg := region{State: stateConst[r.State.Name], Initial: stateConst[r.Initial.Name]}
+			for _, s := range r.Final {
+				reg.Final = append(reg.Final, stateConst[s.Name])
+			}
+			ps.Regions = append(ps.Regions, reg)
+		}
+		m.Parallel = append(m.Parallel, ps)
+	}
+	for _, s := range def.Initial {
+		m.Initial = append(m.Initial, stateConst[s.Name])
+	}
+	for _, s := range def.Terminal {
+		m.Terminal = append(m.Terminal, stateConst[s.Name])
+	}
+	for _, t := range def.Transitions {
+		m.Transitions = append(m.Transitions, transition{
+			From:  stateConst[t.From.Name],
+			To:    stateConst[t.To.Name],
+			Event: eventConst[t.Event.Name],
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := codeTemplate.Execute(&buf, m); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// identifier turns a state or event name such as "in-review" or
+// "fulfillment/picking" into an exported Go identifier: InReview,
+// FulfillmentPicking
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var codeTemplate = template.Must(template.New("fsm").Parse(`// Code generated by fsmgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+// {{.Type}}State is a state of the {{.Name}} workflow
+type {{.Type}}State string
+
+// States of the {{.Name}} workflow
+const (
+{{- range .States}}
+	{{.Name}} {{$.Type}}State = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// {{.Type}}Event is an event of the {{.Name}} workflow
+type {{.Type}}Event string
+
+// Events of the {{.Name}} workflow
+const (
+{{- range .Events}}
+	{{.Name}} {{$.Type}}Event = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// {{.Type}}FSM is the {{.Name}} workflow
+type {{.Type}}FSM struct {
+	*fsm.Typed[{{.Type}}State, {{.Type}}Event]
+}
+
+// New{{.Type}}FSM creates the {{.Name}} workflow. opts are applied after the
+// initial, terminal and nested states of the definition.
+func New{{.Type}}FSM(storage fsm.Storage, opts ...fsm.Option) (*{{.Type}}FSM, error) {
+	defOpts := []fsm.Option{
+{{- if .Initial}}
+		fsm.WithInitialStates(fsm.States({{range $i, $s := .Initial}}{{if $i}}, {{end}}{{$s}}{{end}})...),
+{{- end}}
+{{- if .Terminal}}
+		fsm.WithTerminalStates(fsm.States({{range $i, $s := .Terminal}}{{if $i}}, {{end}}{{$s}}{{end}})...),
+{{- end}}
+{{- range .Substates}}
+		fsm.Substates({{.Parent}}{{range .Children}}, {{.}}{{end}}),
+{{- end}}
+{{- range .Parallel}}
+		fsm.TypedParallel({{.State}}, {{.Join}},
+{{- range .Regions}}
+			fsm.TypedRegion[{{$.Type}}State]{State: {{.State}}, Initial: {{.Initial}}{{if .Final}}, Final: []{{$.Type}}State{ {{- range $i, $s := .Final}}{{if $i}}, {{end}}{{$s}}{{end}}}{{end}}},
+{{- end}}
+		),
+{{- end}}
+	}
+
+	typed, err := fsm.NewTyped(
+		[]{{.Type}}State{
+{{- range .States}}
+			{{.Name}},
+{{- end}}
+		},
+		[]{{.Type}}Event{
+{{- range .Events}}
+			{{.Name}},
+{{- end}}
+		},
+		[]fsm.TypedTransition[{{.Type}}State, {{.Type}}Event]{
+{{- range .Transitions}}
+			{From: {{.From}}, To: {{.To}}, Event: {{.Event}}},
+{{- end}}
+		},
+		storage,
+		append(defOpts, opts...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &{{.Type}}FSM{Typed: typed}, nil
+}
+
+// WithStorage returns a copy of the workflow that uses storage; see
+// fsm.FSM.WithStorage
+func (m *{{.Type}}FSM) WithStorage(storage fsm.Storage) *{{.Type}}FSM {
+	return &{{.Type}}FSM{Typed: m.Typed.WithStorage(storage)}
+}
+{{range .Events}}
+// {{.Method}} triggers the {{.Value}} event{{.Doc}}
+func (m *{{$.Type}}FSM) {{.Method}}(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, {{.Name}}, actor, opts...)
+}
+{{end}}`))
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+func loadDefinition(t *testing.T, input string) *fsm.Definition {
+	t.Helper()
+	def, err := fsm.LoadDefinition(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadDefinition failed: %v", err)
+	}
+	return def
+}
+
+func TestGenerate_ExampleUpToDate(t *testing.T) {
+	f, err := os.Open("../../examples/document.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	def, err := fsm.LoadDefinition(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := generate(def, config{Source: "document.yaml", Package: "main"})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	want, err := os.ReadFile("../../examples/document_fsm.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("examples/document_fsm.go is out of date; run go generate ./examples")
+	}
+}
+
+func TestGenerate_Names(t *testing.T) {
+	def := loadDefinition(t, `
+name: purchase-order
+states: [new, in-review, on_hold]
+events: [send-for-review, hold, reset]
+transitions:
+  - {from: new, event: send-for-review, to: in-review}
+  - {from: in-review, event: hold, to: on_hold}
+  - {from: on_hold, event: reset, to: new}
+`)
+
+	src, err := generate(def, config{Source: "po.yaml", Package: "orders"})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"package orders",
+		"type PurchaseOrderState string",
+		`PurchaseOrderStateInReview PurchaseOrderState = "in-review"`,
+		`PurchaseOrderStateOnHold   PurchaseOrderState = "on_hold"`,
+		`PurchaseOrderEventSendForReview PurchaseOrderEvent = "send-for-review"`,
+		"func NewPurchaseOrderFSM(storage fsm.Storage, opts ...fsm.Option) (*PurchaseOrderFSM, error)",
+		"// SendForReview triggers the send-for-review event (new -> in-review)",
+		"func (m *PurchaseOrderFSM) SendForReview(",
+		"func (m *PurchaseOrderFSM) ResetEvent(",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %q", want)
+		}
+	}
+	if strings.Contains(string(src), "WithInitialStates") {
+		t.Error("expected no WithInitialStates without declared initial states")
+	}
+
+	src, err = generate(def, config{Package: "orders", TypeName: "PO"})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if !strings.Contains(string(src), "type POFSM struct") {
+		t.Error("expected -type to set the type name")
+	}
+}
+
+func TestGenerate_Substates(t *testing.T) {
+	def := loadDefinition(t, `
+name: order
+states:
+  - pending
+  - fulfillment
+  - {name: fulfillment/picking, parent: fulfillment}
+  - {name: fulfillment/packing, parent: fulfillment}
+  - cancelled
+events: [confirm, pack, cancel]
+transitions:
+  - {from: pending, event: confirm, to: fulfillment/picking}
+  - {from: fulfillment/picking, event: pack, to: fulfillment/packing}
+  - {from: fulfillment, event: cancel, to: cancelled}
+`)
+
+	src, err := generate(def, config{Package: "orders"})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	want := "fsm.Substates(OrderStateFulfillment, OrderStateFulfillmentPicking, OrderStateFulfillmentPacking),"
+	if !strings.Contains(string(src), want) {
+		t.Errorf("generated code does not contain %q:\n%s", want, src)
+	}
+}
+
+func TestGenerate_Parallel(t *testing.T) {
+	def := loadDefinition(t, `
+name: loan
+states:
+  - draft
+  - review
+  - documents
+  - {name: collecting, parent: documents}
+  - {name: verified, parent: documents}
+  - credit
+  - {name: checking, parent: credit}
+  - {name: passed, parent: credit}
+  - approved
+events: [submit, verify, pass]
+parallel:
+  - state: review
+    join: approved
+    regions:
+      - {state: documents, initial: collecting, final: [verified]}
+      - {state: credit, initial: checking}
+transitions:
+  - {from: draft, event: submit, to: review}
+  - {from: collecting, event: verify, to: verified}
+  - {from: checking, event: pass, to: passed}
+`)
+
+	src, err := generate(def, config{Package: "loans"})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"fsm.TypedParallel(LoanStateReview, LoanStateApproved,",
+		"fsm.TypedRegion[LoanState]{State: LoanStateDocuments, Initial: LoanStateCollecting, Final: []LoanState{LoanStateVerified}},",
+		"fsm.TypedRegion[LoanState]{State: LoanStateCredit, Initial: LoanStateChecking},",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		cfg   config
+		want  string
+	}{
+		{
+			name: "colliding states",
+			input: `
+name: doc
+states: [in-review, in_review]
+events: [go]
+transitions:
+  - {from: in-review, event: go, to: in_review}
+`,
+			want: "both generate DocStateInReview",
+		},
+		{
+			name: "event without a letter",
+			input: `
+name: doc
+states: [a, b]
+events: ["2nd"]
+transitions:
+  - {from: a, event: "2nd", to: b}
+`,
+			want: "cannot name a method",
+		},
+		{
+			name: "no type name",
+			input: `
+states: [a, b]
+events: [go]
+transitions:
+  - {from: a, event: go, to: b}
+`,
+			want: "pass -type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := loadDefinition(t, tt.input)
+			tt.cfg.Package = "main"
+			_, err := generate(def, tt.cfg)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
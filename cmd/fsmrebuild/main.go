@@ -0,0 +1,75 @@
+// Command fsmrebuild regenerates the current state projection of a
+// PostgreSQL transition table from its history, as
+// fsm.PostgresStorage.RebuildCurrentState does. Run it after modifying the
+// history directly, or after older versions of the package wrote to the
+// table. Writes to the history wait until it finishes.
+//
+// Usage:
+//
+//	fsmrebuild [-dsn conn] [-schema name] [-table name]
+//
+// The connection string defaults to $DATABASE_URL.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fsmrebuild: ")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
+
+// run parses args and rebuilds the projection of the selected table
+func run(ctx context.Context, args []string, output io.Writer) error {
+	flags := flag.NewFlagSet("fsmrebuild", flag.ContinueOnError)
+	flags.SetOutput(output)
+	dsn := flags.String("dsn", os.Getenv("DATABASE_URL"), "PostgreSQL connection string (default $DATABASE_URL)")
+	schema := flags.String("schema", "", "schema of the transition table (default the search_path)")
+	table := flags.String("table", fsm.DefaultPostgresTable, "transition table")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "usage: fsmrebuild [flags]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *dsn == "" {
+		return errors.New("no connection string: pass -dsn or set DATABASE_URL")
+	}
+
+	opts := []fsm.PostgresOption{fsm.WithTable(*table)}
+	if *schema != "" {
+		opts = append(opts, fsm.WithSchema(*schema))
+	}
+	storage, err := fsm.NewPostgresStorage(ctx, *dsn, opts...)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	return storage.RebuildCurrentState(ctx)
+}
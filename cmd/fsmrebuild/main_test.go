@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+func TestRun_Usage(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	ctx := context.Background()
+
+	err := run(ctx, nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no connection string") {
+		t.Errorf("run() error = %v, want missing connection string", err)
+	}
+	if err := run(ctx, []string{"-dsn", "postgres://localhost/fsm", "extra"}, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("run(extra argument) error = %v, want flag.ErrHelp", err)
+	}
+	if err := run(ctx, []string{"-unknown"}, io.Discard); err == nil {
+		t.Error("run(unknown flag) error = nil, want an error")
+	}
+}
+
+func TestRun_Postgres(t *testing.T) {
+	connString := os.Getenv("POSTGRES_TEST_CONN")
+	if connString == "" {
+		t.Skip("Skipping PostgreSQL tests: POSTGRES_TEST_CONN not set")
+	}
+	ctx := context.Background()
+
+	storage, err := fsm.NewPostgresStorage(ctx, connString)
+	if err != nil {
+		t.Fatalf("NewPostgresStorage() error = %v", err)
+	}
+	defer storage.Close()
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	if err := run(ctx, []string{"-dsn", connString}, io.Discard); err != nil {
+		t.Errorf("run() error = %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DataStorage is implemented by storage that keeps extended state data, such
+// as approval counts or assignees, alongside the state of each entity. The
+// data changes only with a transition: CompareAndSaveTransition and
+// SaveTransition store et.Data together with the transition, or keep the
+// current data if et.Data is nil. When the storage implements it, the FSM
+// reads entities through GetEntityData instead of GetEntityState.
+type DataStorage interface {
+	// GetEntityData returns the current state and version of an entity
+	// together with its data, which is nil if none has been saved
+	GetEntityData(ctx context.Context, entity Entity) (EntityState, json.RawMessage, error)
+}
+
+// WithData replaces the entity's data with v, encoded as JSON and saved
+// together with the transition. The storage must implement DataStorage.
+func WithData(v any) TriggerOption {
+	return func(o *triggerOptions) {
+		o.data = func(json.RawMessage) (json.RawMessage, error) {
+			return json.Marshal(v)
+		}
+	}
+}
+
+// WithDataUpdate decodes the entity's current data into a T, calls update on
+// it and saves the result together with the transition. The data is read
+// together with the state, so if another writer changes the entity in
+// between, nothing is saved and ErrConcurrentModification is returned. The
+// storage must implement DataStorage.
+//
+//	err := machine.Trigger(ctx, loan, fsm.Event{Name: "approve"}, "bob",
+//		fsm.WithDataUpdate(func(d *LoanData) error {
+//			d.Approvals++
+//			return nil
+//		}))
+func WithDataUpdate[T any](update func(*T) error) TriggerOption {
+	return func(o *triggerOptions) {
+		o.data = func(current json.RawMessage) (json.RawMessage, error) {
+			v, err := decodeData[T](current)
+			if err != nil {
+				return nil, err
+			}
+			if err := update(&v); err != nil {
+				return nil, err
+			}
+			return json.Marshal(v)
+		}
+	}
+}
+
+// GetData decodes the data of entity into a T. An entity without data yields
+// the zero T. The storage must implement DataStorage.
+func GetData[T any](ctx context.Context, f *FSM, entity Entity) (T, error) {
+	var zero T
+	ds, ok := f.storage.(DataStorage)
+	if !ok {
+		return zero, errUnsupportedData
+	}
+
+	_, data, err := ds.GetEntityData(ctx, entity)
+	if err != nil {
+		return zero, err
+	}
+	return decodeData[T](data)
+}
+
+var errUnsupportedData = fmt.Errorf("%w: storage does not implement DataStorage", errors.ErrUnsupported)
+
+// decodeData decodes data into a T, yielding the zero T for no data
+func decodeData[T any](data json.RawMessage) (T, error) {
+	var v T
+	if len(data) == 0 {
+		return v, nil
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode entity data: %w", err)
+	}
+	return v, nil
+}
+
+// getEntity reads the current state and version of entity and, if the
+// storage keeps it, its data
+func (f *FSM) getEntity(ctx context.Context, entity Entity) (EntityState, json.RawMessage, error) {
+	if ds, ok := f.storage.(DataStorage); ok {
+		return ds.GetEntityData(ctx, entity)
+	}
+	es, err := f.storage.GetEntityState(ctx, entity)
+	return es, nil, err
+}
+
+// nextData returns the data to save with a transition from an entity holding
+// current: the result of the WithData or WithDataUpdate option, or current
+// if there is none
+func (f *FSM) nextData(o triggerOptions, current json.RawMessage) (json.RawMessage, error) {
+	if o.data == nil {
+		return current, nil
+	}
+	if _, ok := f.storage.(DataStorage); !ok {
+		return nil, errUnsupportedData
+	}
+
+	data, err := o.data(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update entity data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,146 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type loanData struct {
+	Amount    int    `json:"amount"`
+	Approvals int    `json:"approvals"`
+	Assignee  string `json:"assignee,omitempty"`
+}
+
+func newLoanFSM(t *testing.T, storage Storage) *FSM {
+	t.Helper()
+
+	// Two approvals are needed for loans over 10000
+	enoughApprovals := func(ctx context.Context, in GuardInput) error {
+		var d loanData
+		if err := json.Unmarshal(in.Data, &d); err != nil {
+			return err
+		}
+		if d.Amount > 10000 && d.Approvals < 2 {
+			return errors.New("needs two approvals")
+		}
+		return nil
+	}
+
+	states := []State{{Name: "review"}, {Name: "approved"}}
+	events := []Event{{Name: "approve"}, {Name: "finalize"}}
+	transitions := []Transition{
+		{From: State{Name: "review"}, To: State{Name: "review"}, Event: Event{Name: "approve"}},
+		{From: State{Name: "review"}, To: State{Name: "approved"}, Event: Event{Name: "finalize"}, Guards: []Guard{enoughApprovals}},
+	}
+
+	fsm, err := New(states, events, transitions, storage)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return fsm
+}
+
+func TestFSM_Data(t *testing.T) {
+	fsm := newLoanFSM(t, NewMemoryStorage())
+	ctx := context.Background()
+	loan := Entity{Type: "loan", ID: "loan-1"}
+
+	approve := WithDataUpdate(func(d *loanData) error {
+		d.Approvals++
+		return nil
+	})
+
+	var afterData []string
+	mustRegister(t, fsm.AfterTransition(Event{Name: "approve"}, func(ctx context.Context, et EntityTransition) error {
+		afterData = append(afterData, string(et.Data))
+		return nil
+	}))
+
+	if err := fsm.Start(ctx, loan, State{Name: "review"}, "alice", WithData(loanData{Amount: 20000})); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := fsm.Trigger(ctx, loan, Event{Name: "approve"}, "bob", approve); err != nil {
+		t.Fatalf("Trigger(approve) error = %v", err)
+	}
+
+	// Guards see the data
+	if fsm.CanTrigger(ctx, loan, Event{Name: "finalize"}) {
+		t.Error("CanTrigger(finalize) = true with one approval, want false")
+	}
+	err := fsm.Trigger(ctx, loan, Event{Name: "finalize"}, "bob")
+	var guardErr *GuardError
+	if !errors.As(err, &guardErr) || guardErr.Reason() != "needs two approvals" {
+		t.Fatalf("Trigger(finalize) error = %v, want a veto", err)
+	}
+
+	if err := fsm.Trigger(ctx, loan, Event{Name: "approve"}, "carol", approve); err != nil {
+		t.Fatalf("Trigger(approve) error = %v", err)
+	}
+	if err := fsm.Trigger(ctx, loan, Event{Name: "finalize"}, "bob"); err != nil {
+		t.Fatalf("Trigger(finalize) error = %v", err)
+	}
+
+	// Data is kept by transitions that do not change it
+	data, err := GetData[loanData](ctx, fsm, loan)
+	if err != nil {
+		t.Fatalf("GetData() error = %v", err)
+	}
+	if data != (loanData{Amount: 20000, Approvals: 2}) {
+		t.Errorf("GetData() = %+v, want amount 20000 with 2 approvals", data)
+	}
+
+	// Hooks see the data after the transition
+	want := []string{`{"amount":20000,"approvals":1}`, `{"amount":20000,"approvals":2}`}
+	if len(afterData) != 2 || afterData[0] != want[0] || afterData[1] != want[1] {
+		t.Errorf("after-transition hooks saw data %v, want %v", afterData, want)
+	}
+}
+
+func TestFSM_DataUpdateError(t *testing.T) {
+	fsm := newLoanFSM(t, NewMemoryStorage())
+	ctx := context.Background()
+	loan := Entity{Type: "loan", ID: "loan-2"}
+
+	if err := fsm.Start(ctx, loan, State{Name: "review"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// An entity without data decodes to the zero value
+	data, err := GetData[loanData](ctx, fsm, loan)
+	if err != nil || data != (loanData{}) {
+		t.Errorf("GetData() = %+v, %v, want zero value", data, err)
+	}
+
+	errNoAssignee := errors.New("no assignee")
+	err = fsm.Trigger(ctx, loan, Event{Name: "approve"}, "bob", WithDataUpdate(func(d *loanData) error {
+		return errNoAssignee
+	}))
+	if !errors.Is(err, errNoAssignee) {
+		t.Fatalf("Trigger() error = %v, want errNoAssignee", err)
+	}
+	if history, _ := fsm.GetTransitions(ctx, loan); len(history) != 1 {
+		t.Errorf("GetTransitions() count = %d after a failed update, want 1", len(history))
+	}
+
+	if _, err := GetData[loanData](ctx, fsm, Entity{Type: "loan", ID: "missing"}); !errors.Is(err, ErrEntityNotFound) {
+		t.Errorf("GetData(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+}
+
+func TestFSM_DataUnsupported(t *testing.T) {
+	fsm := newLoanFSM(t, struct{ Storage }{NewMemoryStorage()})
+	ctx := context.Background()
+	loan := Entity{Type: "loan", ID: "loan-3"}
+
+	if err := fsm.Start(ctx, loan, State{Name: "review"}, "alice", WithData(loanData{Amount: 1})); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Start(WithData) error = %v, want errors.ErrUnsupported", err)
+	}
+	if err := fsm.Start(ctx, loan, State{Name: "review"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, err := GetData[loanData](ctx, fsm, loan); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("GetData() error = %v, want errors.ErrUnsupported", err)
+	}
+}
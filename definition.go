@@ -0,0 +1,457 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition describes a workflow: its states, events and transitions, and the
+// states entities start and finish in. Definitions can be loaded from YAML or
+// JSON with LoadDefinition.
+type Definition struct {
+	Name        string
+	States      []State
+	Events      []Event
+	Transitions []Transition
+	Initial     []State
+	Terminal    []State
+	Parallel    []Parallel
+}
+
+// Options returns the FSM options declared by the definition
+func (d *Definition) Options() []Option {
+	opts := []Option{
+		WithInitialStates(d.Initial...),
+		WithTerminalStates(d.Terminal...),
+	}
+	for _, p := range d.Parallel {
+		opts = append(opts, WithParallel(p))
+	}
+	return opts
+}
+
+// New creates an FSM from the definition
+func (d *Definition) New(storage Storage, opts ...Option) (*FSM, error) {
+	return New(d.States, d.Events, d.Transitions, storage, append(d.Options(), opts...)...)
+}
+
+// DefinitionError reports a problem at a specific place in a definition file
+type DefinitionError struct {
+	Line   int
+	Column int
+	// Field is the path of the offending field, e.g. "transitions[2].to"
+	Field string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s: %v", e.Line, e.Column, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+// LoadDefinition reads a workflow definition in YAML or JSON:
+//
+//	name: document
+//	states: [draft, submitted, approved, rejected, published]
+//	events: [submit, approve, reject, publish, revise]
+//	initial: draft
+//	terminal: [published]
+//	transitions:
+//	  - {from: draft, event: submit, to: submitted}
+//	  - {from: submitted, event: approve, to: approved}
+//
+// States and events may also be written as mappings with a name field. A
+// state mapping can name the composite state it is nested in with a parent
+// field, e.g. {name: fulfillment/picking, parent: fulfillment}.
+//
+// Parallel states are declared in a parallel list, naming for each its
+// regions and the state it joins to once every region is final:
+//
+//	parallel:
+//	  - state: review
+//	    join: decided
+//	    regions:
+//	      - {state: documents, initial: documents/pending, final: [documents/verified]}
+//	      - {state: credit, initial: credit/pending, final: [credit/passed]}
+//
+// The regions are nested in the parallel state and need not name it as
+// their parent.
+//
+// Every problem found is reported as a *DefinitionError; they are joined
+// into the returned error.
+func LoadDefinition(r io.Reader) (*Definition, error) {
+	var root yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty definition")
+		}
+		return nil, fmt.Errorf("failed to parse definition: %w", err)
+	}
+
+	p := &definitionParser{}
+	def := p.parse(root.Content[0])
+	if len(p.errs) > 0 {
+		return nil, errors.Join(p.errs...)
+	}
+
+	return def, nil
+}
+
+// definitionParser walks a YAML node tree, collecting errors as it goes
+type definitionParser struct {
+	errs []error
+}
+
+func (p *definitionParser) errorf(n *yaml.Node, field, format string, args ...any) {
+	p.errs = append(p.errs, &DefinitionError{
+		Line:   n.Line,
+		Column: n.Column,
+		Field:  field,
+		Err:    fmt.Errorf(format, args...),
+	})
+}
+
+func (p *definitionParser) parse(doc *yaml.Node) *Definition {
+	fields := p.mapping(doc, "definition", "name", "states", "events", "initial", "terminal", "transitions", "parallel")
+	if fields == nil {
+		return nil
+	}
+	for _, key := range []string{"states", "events", "transitions"} {
+		if fields[key] == nil {
+			p.errorf(doc, key, "is required")
+		}
+	}
+
+	def := &Definition{}
+	if n := fields["name"]; n != nil {
+		def.Name = p.scalar(n, "name")
+	}
+
+	states := make(map[string]bool)
+	stateDecls := p.names(fields["states"], "states", "state", "parent")
+	for _, d := range stateDecls {
+		states[d.name] = true
+	}
+	for _, d := range stateDecls {
+		state := State{Name: d.name}
+		if n := d.fields["parent"]; n != nil {
+			state.Parent, _ = p.stateRef(n, d.field+".parent", states)
+		}
+		def.States = append(def.States, state)
+	}
+
+	def.Parallel = p.parallel(fields["parallel"], states)
+
+	var substates []State
+	for _, ps := range def.Parallel {
+		substates = append(substates, ps.substates()...)
+	}
+	tree, err := newStateTree(def.States, substates)
+	if err != nil {
+		p.errorf(fields["states"], "states", "%w", err)
+		tree = &stateTree{}
+	}
+
+	// Entities rest in parallel states but not in other composite states
+	composite := maps.Clone(tree.composite)
+	for _, ps := range def.Parallel {
+		delete(composite, ps.State.Name)
+	}
+
+	events := make(map[string]bool)
+	for _, d := range p.names(fields["events"], "events", "event") {
+		events[d.name] = true
+		def.Events = append(def.Events, Event{Name: d.name})
+	}
+
+	def.Initial = p.stateRefs(fields["initial"], "initial", states, composite)
+	def.Terminal = p.stateRefs(fields["terminal"], "terminal", states, nil)
+	def.Transitions = p.transitions(fields["transitions"], states, composite, events)
+
+	return def
+}
+
+// mapping returns the values of a mapping node by key, reporting unknown and
+// duplicate keys
+func (p *definitionParser) mapping(n *yaml.Node, field string, allowed ...string) map[string]*yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, field, "expected a mapping")
+		return nil
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		known := false
+		for _, a := range allowed {
+			if key.Value == a {
+				known = true
+				break
+			}
+		}
+		switch {
+		case !known:
+			p.errorf(key, field, "unknown field %q", key.Value)
+		case fields[key.Value] != nil:
+			p.errorf(key, field, "duplicate field %q", key.Value)
+		default:
+			fields[key.Value] = value
+		}
+	}
+	return fields
+}
+
+func (p *definitionParser) scalar(n *yaml.Node, field string) string {
+	if n.Kind != yaml.ScalarNode {
+		p.errorf(n, field, "expected a string")
+		return ""
+	}
+	if n.Value == "" {
+		p.errorf(n, field, "must not be empty")
+	}
+	return n.Value
+}
+
+// declaration is a state or event declared in a definition
+type declaration struct {
+	name string
+	// field is the path of the declaration, e.g. "states[2]"
+	field string
+	// fields holds the extra fields of a declaration written as a mapping
+	fields map[string]*yaml.Node
+}
+
+// names parses a list of state or event declarations, each either a plain
+// name or a mapping with a name field and any of the extra fields
+func (p *definitionParser) names(n *yaml.Node, field, kind string, extra ...string) []declaration {
+	if n == nil {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, field, "expected a list")
+		return nil
+	}
+	if len(n.Content) == 0 {
+		p.errorf(n, field, "must not be empty")
+	}
+
+	var decls []declaration
+	seen := make(map[string]bool)
+	for i, item := range n.Content {
+		d := declaration{field: fmt.Sprintf("%s[%d]", field, i)}
+		node, nameField := item, d.field
+		if item.Kind == yaml.MappingNode {
+			d.fields = p.mapping(item, d.field, append([]string{"name"}, extra...)...)
+			if d.fields["name"] == nil {
+				p.errorf(item, d.field+".name", "is required")
+				continue
+			}
+			node, nameField = d.fields["name"], d.field+".name"
+		}
+
+		d.name = p.scalar(node, nameField)
+		if d.name == "" {
+			continue
+		}
+		if seen[d.name] {
+			p.errorf(node, nameField, "duplicate %s %q", kind, d.name)
+			continue
+		}
+		seen[d.name] = true
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+// stateRefs parses a state name or list of state names that must be declared
+// and must not be one of the composite states
+func (p *definitionParser) stateRefs(n *yaml.Node, field string, states, composite map[string]bool) []State {
+	if n == nil {
+		return nil
+	}
+
+	nodes := []*yaml.Node{n}
+	if n.Kind == yaml.SequenceNode {
+		nodes = n.Content
+	}
+
+	var refs []State
+	for i, item := range nodes {
+		itemField := field
+		if n.Kind == yaml.SequenceNode {
+			itemField = fmt.Sprintf("%s[%d]", field, i)
+		}
+		if name, ok := p.leafRef(item, itemField, states, composite); ok {
+			refs = append(refs, State{Name: name})
+		}
+	}
+	return refs
+}
+
+func (p *definitionParser) stateRef(n *yaml.Node, field string, states map[string]bool) (string, bool) {
+	name := p.scalar(n, field)
+	if name == "" {
+		return "", false
+	}
+	if !states[name] {
+		p.errorf(n, field, "%w: state %q is not declared", ErrInvalidState, name)
+		return "", false
+	}
+	return name, true
+}
+
+// leafRef parses a state name that must be declared and must not be one of
+// the composite states
+func (p *definitionParser) leafRef(n *yaml.Node, field string, states, composite map[string]bool) (string, bool) {
+	name, ok := p.stateRef(n, field, states)
+	if ok && composite[name] {
+		p.errorf(n, field, "%w: state %q is a composite state", ErrInvalidState, name)
+		return "", false
+	}
+	return name, ok
+}
+
+func (p *definitionParser) transitions(n *yaml.Node, states, composite, events map[string]bool) []Transition {
+	if n == nil {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, "transitions", "expected a list")
+		return nil
+	}
+	if len(n.Content) == 0 {
+		p.errorf(n, "transitions", "must not be empty")
+	}
+
+	var transitions []Transition
+	for i, item := range n.Content {
+		field := fmt.Sprintf("transitions[%d]", i)
+		fields := p.mapping(item, field, "from", "to", "event")
+		if fields == nil {
+			continue
+		}
+
+		ok := true
+		for _, key := range []string{"from", "to", "event"} {
+			if fields[key] == nil {
+				p.errorf(item, field+"."+key, "is required")
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		from, fromOK := p.stateRef(fields["from"], field+".from", states)
+		to, toOK := p.leafRef(fields["to"], field+".to", states, composite)
+
+		event := p.scalar(fields["event"], field+".event")
+		eventOK := event != ""
+		if eventOK && !events[event] {
+			p.errorf(fields["event"], field+".event", "%w: event %q is not declared", ErrInvalidEvent, event)
+			eventOK = false
+		}
+
+		if fromOK && toOK && eventOK {
+			transitions = append(transitions, Transition{
+				From:  State{Name: from},
+				To:    State{Name: to},
+				Event: Event{Name: event},
+			})
+		}
+	}
+	return transitions
+}
+
+func (p *definitionParser) parallel(n *yaml.Node, states map[string]bool) []Parallel {
+	if n == nil {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, "parallel", "expected a list")
+		return nil
+	}
+
+	var parallel []Parallel
+	for i, item := range n.Content {
+		field := fmt.Sprintf("parallel[%d]", i)
+		fields := p.mapping(item, field, "state", "join", "regions")
+		if fields == nil {
+			continue
+		}
+		for _, key := range []string{"state", "regions"} {
+			if fields[key] == nil {
+				p.errorf(item, field+"."+key, "is required")
+			}
+		}
+		if fields["state"] == nil || fields["regions"] == nil {
+			continue
+		}
+
+		var ps Parallel
+		name, ok := p.stateRef(fields["state"], field+".state", states)
+		ps.State.Name = name
+		if n := fields["join"]; n != nil {
+			name, joinOK := p.stateRef(n, field+".join", states)
+			ps.Join.Name = name
+			ok = ok && joinOK
+		}
+		regions, regionsOK := p.regions(fields["regions"], field+".regions", states)
+		ps.Regions = regions
+		ok = ok && regionsOK
+
+		if ok {
+			parallel = append(parallel, ps)
+		}
+	}
+	return parallel
+}
+
+// regions parses the regions of a parallel state, reporting whether all of
+// them are valid
+func (p *definitionParser) regions(n *yaml.Node, field string, states map[string]bool) ([]Region, bool) {
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, field, "expected a list")
+		return nil, false
+	}
+	if len(n.Content) == 0 {
+		p.errorf(n, field, "must not be empty")
+		return nil, false
+	}
+
+	ok := true
+	var regions []Region
+	for i, item := range n.Content {
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		fields := p.mapping(item, itemField, "state", "initial", "final")
+		if fields == nil {
+			ok = false
+			continue
+		}
+
+		var r Region
+		refs := map[string]*State{"state": &r.State, "initial": &r.Initial}
+		for _, key := range []string{"state", "initial"} {
+			if fields[key] == nil {
+				p.errorf(item, itemField+"."+key, "is required")
+				ok = false
+				continue
+			}
+			name, refOK := p.stateRef(fields[key], itemField+"."+key, states)
+			refs[key].Name = name
+			ok = ok && refOK
+		}
+		r.Final = p.stateRefs(fields["final"], itemField+".final", states, nil)
+		regions = append(regions, r)
+	}
+	return regions, ok
+}
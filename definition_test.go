@@ -0,0 +1,225 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testDefinitionYAML = `
+name: document
+states:
+  - draft
+  - submitted
+  - approved
+  - rejected
+  - name: published
+events: [submit, approve, reject, publish, revise]
+initial: draft
+terminal: [published]
+transitions:
+  - {from: draft, event: submit, to: submitted}
+  - {from: submitted, event: approve, to: approved}
+  - {from: submitted, event: reject, to: rejected}
+  - {from: approved, event: publish, to: published}
+  - from: rejected
+    event: revise
+    to: draft
+`
+
+func TestLoadDefinition_YAML(t *testing.T) {
+	def, err := LoadDefinition(strings.NewReader(testDefinitionYAML))
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+
+	if def.Name != "document" {
+		t.Errorf("Name = %v, want document", def.Name)
+	}
+	if len(def.States) != 5 || def.States[4].Name != "published" {
+		t.Errorf("States = %v, want 5 states ending with published", def.States)
+	}
+	if len(def.Events) != 5 {
+		t.Errorf("Events count = %v, want 5", len(def.Events))
+	}
+	if len(def.Transitions) != 5 || def.Transitions[4].From.Name != "rejected" {
+		t.Errorf("Transitions = %v, want 5 transitions ending with revise", def.Transitions)
+	}
+	if len(def.Initial) != 1 || def.Initial[0].Name != "draft" {
+		t.Errorf("Initial = %v, want [draft]", def.Initial)
+	}
+	if len(def.Terminal) != 1 || def.Terminal[0].Name != "published" {
+		t.Errorf("Terminal = %v, want [published]", def.Terminal)
+	}
+
+	// The definition can be used to build a working FSM
+	fsm, err := def.New(NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("Definition.New() error = %v", err)
+	}
+
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-1"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := fsm.Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+}
+
+func TestLoadDefinition_JSON(t *testing.T) {
+	input := `{
+  "states": ["pending", "active"],
+  "events": ["activate"],
+  "initial": ["pending"],
+  "transitions": [
+    {"from": "pending", "event": "activate", "to": "active"}
+  ]
+}`
+
+	def, err := LoadDefinition(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+
+	if _, err := New(def.States, def.Events, def.Transitions, NewMemoryStorage(), def.Options()...); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+}
+
+func TestLoadDefinition_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "unknown state in transition",
+			input: `states: [draft, submitted]
+events: [submit]
+transitions:
+  - {from: draft, event: submit, to: submited}
+`,
+			want:    []string{"line 4, column 38: transitions[0].to:", `state "submited" is not declared`},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name: "unknown event in transition",
+			input: `states: [draft, submitted]
+events: [submit]
+transitions:
+  - from: draft
+    event: sumbit
+    to: submitted
+`,
+			want:    []string{"line 5, column 12: transitions[0].event:", `event "sumbit" is not declared`},
+			wantErr: ErrInvalidEvent,
+		},
+		{
+			name: "missing sections",
+			input: `states: [draft]
+`,
+			want: []string{"events: is required", "transitions: is required"},
+		},
+		{
+			name: "unknown field",
+			input: `states: [draft]
+events: [submit]
+terminals: [draft]
+transitions:
+  - {from: draft, event: submit, to: draft, guard: x}
+`,
+			want: []string{`line 3, column 1: definition: unknown field "terminals"`, `transitions[0]: unknown field "guard"`},
+		},
+		{
+			name: "duplicate state",
+			input: `states: [draft, draft]
+events: [submit]
+transitions:
+  - {from: draft, event: submit, to: draft}
+`,
+			want: []string{`line 1, column 17: states[1]: duplicate state "draft"`},
+		},
+		{
+			name: "missing transition field",
+			input: `states: [draft]
+events: [submit]
+transitions:
+  - {from: draft, event: submit}
+`,
+			want: []string{"line 4, column 5: transitions[0].to: is required"},
+		},
+		{
+			name: "unknown terminal state",
+			input: `states: [draft]
+events: [submit]
+terminal: published
+transitions:
+  - {from: draft, event: submit, to: draft}
+`,
+			want:    []string{`line 3, column 11: terminal: invalid state: state "published" is not declared`},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name:  "not a mapping",
+			input: `[draft]`,
+			want:  []string{"definition: expected a mapping"},
+		},
+		{
+			name:  "syntax error",
+			input: "states: [draft\n",
+			want:  []string{"failed to parse definition"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadDefinition(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("LoadDefinition() error = nil, want error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadDefinition() error = %q, want it to contain %q", err, want)
+				}
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("LoadDefinition() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDefinition_ErrorDetails(t *testing.T) {
+	input := `states: [draft]
+events: [submit]
+transitions:
+  - {from: drafts, event: submit, to: draft}
+`
+	_, err := LoadDefinition(strings.NewReader(input))
+
+	var defErr *DefinitionError
+	if !errors.As(err, &defErr) {
+		t.Fatalf("LoadDefinition() error = %T, want *DefinitionError", err)
+	}
+	if defErr.Line != 4 || defErr.Field != "transitions[0].from" {
+		t.Errorf("DefinitionError = line %d field %q, want line 4 field transitions[0].from", defErr.Line, defErr.Field)
+	}
+}
+
+func TestNew_InvalidInitialAndTerminalStates(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	_, err := New(testStates, testEvents, testTransitions, storage, WithInitialStates(State{Name: "drafted"}))
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("New(WithInitialStates) error = %v, want ErrInvalidState", err)
+	}
+
+	_, err = New(testStates, testEvents, testTransitions, storage, WithTerminalStates(State{Name: "done"}))
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("New(WithTerminalStates) error = %v, want ErrInvalidState", err)
+	}
+}
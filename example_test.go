@@ -161,3 +161,46 @@ func ExampleFSM_GetNextState() {
 	// Output:
 	// Next state: submitted
 }
+
+type DocState string
+
+type DocEvent string
+
+const (
+	Draft     DocState = "draft"
+	Submitted DocState = "submitted"
+	Approved  DocState = "approved"
+
+	Submit  DocEvent = "submit"
+	Approve DocEvent = "approve"
+)
+
+func ExampleNewTyped() {
+	machine, err := fsm.NewTyped(
+		[]DocState{Draft, Submitted, Approved},
+		[]DocEvent{Submit, Approve},
+		[]fsm.TypedTransition[DocState, DocEvent]{
+			{From: Draft, To: Submitted, Event: Submit},
+			{From: Submitted, To: Approved, Event: Approve},
+		},
+		fsm.NewMemoryStorage(),
+		fsm.WithInitialStates(fsm.States(Draft)...),
+		fsm.WithTerminalStates(fsm.States(Approved)...),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	doc := fsm.Entity{Type: "document", ID: "doc-1"}
+
+	machine.Start(ctx, doc, Draft, "alice")
+	machine.Trigger(ctx, doc, Submit, "alice")
+
+	state, _ := machine.GetState(ctx, doc)
+	events, _ := machine.GetAvailableEvents(ctx, doc)
+	fmt.Printf("State: %s, available: %v\n", state, events)
+
+	// Output:
+	// State: submitted, available: [approve]
+}
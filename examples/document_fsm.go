@@ -0,0 +1,108 @@
+// Code generated by fsmgen from document.yaml. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+// DocumentState is a state of the document workflow
+type DocumentState string
+
+// States of the document workflow
+const (
+	DocumentStateDraft     DocumentState = "draft"
+	DocumentStateSubmitted DocumentState = "submitted"
+	DocumentStateApproved  DocumentState = "approved"
+	DocumentStateRejected  DocumentState = "rejected"
+	DocumentStatePublished DocumentState = "published"
+)
+
+// DocumentEvent is an event of the document workflow
+type DocumentEvent string
+
+// Events of the document workflow
+const (
+	DocumentEventSubmit  DocumentEvent = "submit"
+	DocumentEventApprove DocumentEvent = "approve"
+	DocumentEventReject  DocumentEvent = "reject"
+	DocumentEventPublish DocumentEvent = "publish"
+	DocumentEventRevise  DocumentEvent = "revise"
+)
+
+// DocumentFSM is the document workflow
+type DocumentFSM struct {
+	*fsm.Typed[DocumentState, DocumentEvent]
+}
+
+// NewDocumentFSM creates the document workflow. opts are applied after the
+// initial, terminal and nested states of the definition.
+func NewDocumentFSM(storage fsm.Storage, opts ...fsm.Option) (*DocumentFSM, error) {
+	defOpts := []fsm.Option{
+		fsm.WithInitialStates(fsm.States(DocumentStateDraft)...),
+		fsm.WithTerminalStates(fsm.States(DocumentStatePublished)...),
+	}
+
+	typed, err := fsm.NewTyped(
+		[]DocumentState{
+			DocumentStateDraft,
+			DocumentStateSubmitted,
+			DocumentStateApproved,
+			DocumentStateRejected,
+			DocumentStatePublished,
+		},
+		[]DocumentEvent{
+			DocumentEventSubmit,
+			DocumentEventApprove,
+			DocumentEventReject,
+			DocumentEventPublish,
+			DocumentEventRevise,
+		},
+		[]fsm.TypedTransition[DocumentState, DocumentEvent]{
+			{From: DocumentStateDraft, To: DocumentStateSubmitted, Event: DocumentEventSubmit},
+			{From: DocumentStateSubmitted, To: DocumentStateApproved, Event: DocumentEventApprove},
+			{From: DocumentStateSubmitted, To: DocumentStateRejected, Event: DocumentEventReject},
+			{From: DocumentStateApproved, To: DocumentStatePublished, Event: DocumentEventPublish},
+			{From: DocumentStateRejected, To: DocumentStateDraft, Event: DocumentEventRevise},
+		},
+		storage,
+		append(defOpts, opts...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentFSM{Typed: typed}, nil
+}
+
+// WithStorage returns a copy of the workflow that uses storage; see
+// fsm.FSM.WithStorage
+func (m *DocumentFSM) WithStorage(storage fsm.Storage) *DocumentFSM {
+	return &DocumentFSM{Typed: m.Typed.WithStorage(storage)}
+}
+
+// Submit triggers the submit event (draft -> submitted)
+func (m *DocumentFSM) Submit(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, DocumentEventSubmit, actor, opts...)
+}
+
+// Approve triggers the approve event (submitted -> approved)
+func (m *DocumentFSM) Approve(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, DocumentEventApprove, actor, opts...)
+}
+
+// Reject triggers the reject event (submitted -> rejected)
+func (m *DocumentFSM) Reject(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, DocumentEventReject, actor, opts...)
+}
+
+// Publish triggers the publish event (approved -> published)
+func (m *DocumentFSM) Publish(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, DocumentEventPublish, actor, opts...)
+}
+
+// Revise triggers the revise event (rejected -> draft)
+func (m *DocumentFSM) Revise(ctx context.Context, entity fsm.Entity, actor string, opts ...fsm.TriggerOption) error {
+	return m.Trigger(ctx, entity, DocumentEventRevise, actor, opts...)
+}
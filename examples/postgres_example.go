@@ -1,3 +1,5 @@
+//go:generate go run ../cmd/fsmgen document.yaml
+
 package main
 
 import (
@@ -23,35 +25,14 @@ func main() {
 	}
 	defer storage.Close()
 
-	// Define workflow states
-	states := []fsm.State{
-		{Name: "draft"},
-		{Name: "submitted"},
-		{Name: "approved"},
-		{Name: "rejected"},
-		{Name: "published"},
-	}
-
-	// Define events
-	events := []fsm.Event{
-		{Name: "submit"},
-		{Name: "approve"},
-		{Name: "reject"},
-		{Name: "publish"},
-		{Name: "revise"},
-	}
-
-	// Define transitions
-	transitions := []fsm.Transition{
-		{From: fsm.State{Name: "draft"}, To: fsm.State{Name: "submitted"}, Event: fsm.Event{Name: "submit"}},
-		{From: fsm.State{Name: "submitted"}, To: fsm.State{Name: "approved"}, Event: fsm.Event{Name: "approve"}},
-		{From: fsm.State{Name: "submitted"}, To: fsm.State{Name: "rejected"}, Event: fsm.Event{Name: "reject"}},
-		{From: fsm.State{Name: "approved"}, To: fsm.State{Name: "published"}, Event: fsm.Event{Name: "publish"}},
-		{From: fsm.State{Name: "rejected"}, To: fsm.State{Name: "draft"}, Event: fsm.Event{Name: "revise"}},
+	// Create or update the transition table
+	if err := storage.Migrate(ctx); err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
 	}
 
-	// Create FSM with PostgreSQL storage
-	machine, err := fsm.New(states, events, transitions, storage)
+	// Create FSM with PostgreSQL storage. NewDocumentFSM and the state and
+	// event constants are generated from document.yaml by fsmgen.
+	machine, err := NewDocumentFSM(storage)
 	if err != nil {
 		log.Fatalf("Failed to create FSM: %v", err)
 	}
@@ -65,14 +46,14 @@ func main() {
 	fmt.Printf("Document ID: %s\n", document.ID)
 
 	// Start document in draft state
-	err = machine.Start(ctx, document, fsm.State{Name: "draft"}, "alice")
+	err = machine.Start(ctx, document, DocumentStateDraft, "alice")
 	if err != nil {
 		log.Fatalf("Failed to start: %v", err)
 	}
 	fmt.Println("✓ Document started in 'draft' state")
 
 	// Submit the document
-	err = machine.Trigger(ctx, document, fsm.Event{Name: "submit"}, "alice")
+	err = machine.Submit(ctx, document, "alice")
 	if err != nil {
 		log.Fatalf("Failed to submit: %v", err)
 	}
@@ -83,7 +64,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to get state: %v", err)
 	}
-	fmt.Printf("Current state: %s\n", currentState.Name)
+	fmt.Printf("Current state: %s\n", currentState)
 
 	// Check available events
 	availableEvents, err := machine.GetAvailableEvents(ctx, document)
@@ -95,19 +76,19 @@ func main() {
 		if i > 0 {
 			fmt.Print(", ")
 		}
-		fmt.Print(event.Name)
+		fmt.Print(event)
 	}
 	fmt.Println()
 
 	// Approve the document
-	err = machine.Trigger(ctx, document, fsm.Event{Name: "approve"}, "bob")
+	err = machine.Approve(ctx, document, "bob")
 	if err != nil {
 		log.Fatalf("Failed to approve: %v", err)
 	}
 	fmt.Println("✓ Document approved by bob")
 
 	// Publish the document
-	err = machine.Trigger(ctx, document, fsm.Event{Name: "publish"}, "charlie")
+	err = machine.Publish(ctx, document, "charlie")
 	if err != nil {
 		log.Fatalf("Failed to publish: %v", err)
 	}
@@ -118,10 +99,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to get final state: %v", err)
 	}
-	fmt.Printf("Final state: %s\n", finalState.Name)
+	fmt.Printf("Final state: %s\n", finalState)
 
 	// Get complete transition history
-	history, err := machine.GetTransitions(ctx, document)
+	history, err := machine.FSM().GetTransitions(ctx, document)
 	if err != nil {
 		log.Fatalf("Failed to get history: %v", err)
 	}
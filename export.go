@@ -0,0 +1,304 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// TransitionKey identifies an edge of the state graph. Start transitions
+// have an empty From.
+type TransitionKey struct {
+	From  string
+	Event string
+	To    string
+}
+
+// ExportOption configures WriteDOT and WriteMermaid
+type ExportOption func(*exportOptions)
+
+type exportOptions struct {
+	counts map[TransitionKey]int
+}
+
+// WithTransitionCounts labels each edge with the number of times it was taken,
+// as returned by CountTransitions
+func WithTransitionCounts(counts map[TransitionKey]int) ExportOption {
+	return func(o *exportOptions) {
+		o.counts = counts
+	}
+}
+
+// CountTransitions tallies how often each transition appears in the stored
+// history of the given entities
+func (f *FSM) CountTransitions(ctx context.Context, entities ...Entity) (map[TransitionKey]int, error) {
+	counts := make(map[TransitionKey]int)
+	for _, entity := range entities {
+		history, err := f.storage.GetTransitions(ctx, entity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transitions for %s/%s: %w", entity.Type, entity.ID, err)
+		}
+		var regions []State
+		for _, et := range history {
+			counts[keyOf(et.Transition)]++
+			// A move within a parallel state also counts the moves of its
+			// regions
+			if et.Transition.From.Name == et.Transition.To.Name && len(regions) == len(et.Regions) {
+				for i, r := range et.Regions {
+					if r.Name != regions[i].Name {
+						counts[TransitionKey{From: regions[i].Name, Event: et.Transition.Event.Name, To: r.Name}]++
+					}
+				}
+			}
+			regions = et.Regions
+		}
+	}
+	return counts, nil
+}
+
+// WriteDOT renders the state graph in Graphviz DOT format. Initial states are
+// drawn bold with an arrow from a start point, terminal states with a double border.
+// Substates are grouped in a cluster together with their composite state,
+// which is drawn dashed. A parallel state has an edge labelled "join" to its
+// join state.
+func (f *FSM) WriteDOT(w io.Writer, opts ...ExportOption) error {
+	o := applyExportOptions(opts)
+
+	var b strings.Builder
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	if len(f.initialStates) > 0 {
+		b.WriteString("\t__start [shape=point];\n")
+	}
+	for _, s := range f.states {
+		var attrs []string
+		if f.isInitial(s) {
+			attrs = append(attrs, `style="rounded,bold"`)
+		}
+		if f.isTerminal(s) {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if f.tree.isComposite(s.Name) {
+			attrs = append(attrs, `style="rounded,dashed"`)
+		}
+		fmt.Fprintf(&b, "\t%s", dotQuote(s.Name))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	for _, s := range f.states {
+		if f.tree.isComposite(s.Name) && f.tree.parent[s.Name] == "" {
+			f.writeDOTCluster(&b, s.Name, "\t")
+		}
+	}
+
+	for _, s := range f.initialStates {
+		fmt.Fprintf(&b, "\t__start -> %s", dotQuote(s.Name))
+		if label := o.startLabel(s); label != "" {
+			fmt.Fprintf(&b, " [label=%s]", dotQuote(label))
+		}
+		b.WriteString(";\n")
+	}
+	for _, t := range append(f.transitions[:len(f.transitions):len(f.transitions)], f.joins()...) {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n",
+			dotQuote(t.From.Name), dotQuote(t.To.Name), dotQuote(o.label(t)))
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid renders the state graph as a Mermaid stateDiagram-v2. Initial
+// and terminal states are connected to the start and end markers and styled
+// with the "initial" and "terminal" classes. Substates are drawn inside their
+// composite state, and the regions of a parallel state are separated by "--",
+// each with its initial state connected to a start marker.
+func (f *FSM) WriteMermaid(w io.Writer, opts ...ExportOption) error {
+	o := applyExportOptions(opts)
+	ids := mermaidIDs(f.states)
+
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+
+	for _, s := range f.states {
+		if ids[s.Name] != s.Name {
+			fmt.Fprintf(&b, "    state \"%s\" as %s\n", strings.ReplaceAll(s.Name, `"`, "#quot;"), ids[s.Name])
+		}
+	}
+	for _, s := range f.states {
+		if f.tree.isComposite(s.Name) && f.tree.parent[s.Name] == "" {
+			f.writeMermaidComposite(&b, ids, s.Name, "    ")
+		}
+	}
+
+	for _, s := range f.initialStates {
+		fmt.Fprintf(&b, "    [*] --> %s", ids[s.Name])
+		if label := o.startLabel(s); label != "" {
+			fmt.Fprintf(&b, " : %s", label)
+		}
+		b.WriteString("\n")
+	}
+	for _, t := range append(f.transitions[:len(f.transitions):len(f.transitions)], f.joins()...) {
+		fmt.Fprintf(&b, "    %s --> %s : %s\n", ids[t.From.Name], ids[t.To.Name], o.label(t))
+	}
+	for _, s := range f.terminalStates {
+		fmt.Fprintf(&b, "    %s --> [*]\n", ids[s.Name])
+	}
+
+	if len(f.initialStates) > 0 {
+		b.WriteString("    classDef initial font-weight:bold\n")
+		for _, s := range f.initialStates {
+			fmt.Fprintf(&b, "    class %s initial\n", ids[s.Name])
+		}
+	}
+	if len(f.terminalStates) > 0 {
+		b.WriteString("    classDef terminal stroke-width:3px\n")
+		for _, s := range f.terminalStates {
+			fmt.Fprintf(&b, "    class %s terminal\n", ids[s.Name])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDOTCluster writes the cluster of a composite state, nesting the
+// clusters of its composite substates
+func (f *FSM) writeDOTCluster(b *strings.Builder, name, indent string) {
+	fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+name))
+	fmt.Fprintf(b, "%s\tlabel=%s;\n", indent, dotQuote(name))
+	fmt.Fprintf(b, "%s\t%s;\n", indent, dotQuote(name))
+	for _, s := range f.states {
+		if f.tree.parent[s.Name] != name {
+			continue
+		}
+		if f.tree.isComposite(s.Name) {
+			f.writeDOTCluster(b, s.Name, indent+"\t")
+		} else {
+			fmt.Fprintf(b, "%s\t%s;\n", indent, dotQuote(s.Name))
+		}
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// writeMermaidComposite writes the block of a composite state listing its
+// substates, nesting the blocks of its composite substates
+func (f *FSM) writeMermaidComposite(b *strings.Builder, ids map[string]string, name, indent string) {
+	fmt.Fprintf(b, "%sstate %s {\n", indent, ids[name])
+	if r := f.region(name); r != nil {
+		fmt.Fprintf(b, "%s    [*] --> %s\n", indent, ids[r.Initial.Name])
+	}
+	parallel := f.parallelState(name) != nil
+	first := true
+	for _, s := range f.states {
+		if f.tree.parent[s.Name] != name {
+			continue
+		}
+		if parallel && !first {
+			fmt.Fprintf(b, "%s    --\n", indent)
+		}
+		first = false
+		if f.tree.isComposite(s.Name) {
+			f.writeMermaidComposite(b, ids, s.Name, indent+"    ")
+		} else {
+			fmt.Fprintf(b, "%s    %s\n", indent, ids[s.Name])
+		}
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func applyExportOptions(opts []ExportOption) exportOptions {
+	var o exportOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// label returns the edge label for t: the event name, a marker for guarded
+// transitions and the transition count if counts were given
+func (o exportOptions) label(t Transition) string {
+	label := t.Event.Name
+	if len(t.Guards) > 0 {
+		label += " [guarded]"
+	}
+	if o.counts != nil {
+		label += fmt.Sprintf(" (%d)", o.counts[keyOf(t)])
+	}
+	return label
+}
+
+// startLabel returns the label for the edge into an initial state, which is
+// only labelled when counts were given
+func (o exportOptions) startLabel(s State) string {
+	if o.counts == nil {
+		return ""
+	}
+	var n int
+	for k, c := range o.counts {
+		if k.From == "" && k.To == s.Name {
+			n += c
+		}
+	}
+	return fmt.Sprintf("(%d)", n)
+}
+
+func keyOf(t Transition) TransitionKey {
+	return TransitionKey{From: t.From.Name, Event: t.Event.Name, To: t.To.Name}
+}
+
+func (f *FSM) isInitial(s State) bool {
+	return validateState(s, f.initialStates) == nil
+}
+
+// isTerminal reports whether s or a composite state containing it is terminal
+func (f *FSM) isTerminal(s State) bool {
+	for _, name := range f.tree.lineage(s.Name) {
+		if validateState(State{Name: name}, f.terminalStates) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+var mermaidIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// mermaidIDs maps state names to Mermaid identifiers. Names that are not
+// plain identifiers, or are Mermaid keywords, get generated aliases.
+func mermaidIDs(states []State) map[string]string {
+	names := make(map[string]bool, len(states))
+	for _, s := range states {
+		names[s.Name] = true
+	}
+
+	ids := make(map[string]string, len(states))
+	for i, s := range states {
+		switch s.Name {
+		case "state", "end", "note", "direction", "class", "classDef":
+		default:
+			if mermaidIdentifier.MatchString(s.Name) {
+				ids[s.Name] = s.Name
+				continue
+			}
+		}
+		alias := fmt.Sprintf("s%d", i)
+		for names[alias] {
+			alias += "_"
+		}
+		ids[s.Name] = alias
+	}
+	return ids
+}
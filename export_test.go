@@ -0,0 +1,169 @@
+package fsm
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func newExportFSM(t *testing.T) *FSM {
+	fsm, err := New(testStates, testEvents, testTransitions, NewMemoryStorage(),
+		WithInitialStates(State{Name: "draft"}),
+		WithTerminalStates(State{Name: "published"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+	return fsm
+}
+
+func TestFSM_WriteDOT(t *testing.T) {
+	fsm := newExportFSM(t)
+
+	var b strings.Builder
+	if err := fsm.WriteDOT(&b); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+
+	want := `digraph fsm {
+	rankdir=LR;
+	node [shape=box, style=rounded];
+	__start [shape=point];
+	"draft" [style="rounded,bold"];
+	"submitted";
+	"approved";
+	"rejected";
+	"published" [peripheries=2];
+	__start -> "draft";
+	"draft" -> "submitted" [label="submit"];
+	"submitted" -> "approved" [label="approve"];
+	"submitted" -> "rejected" [label="reject"];
+	"approved" -> "published" [label="publish"];
+	"rejected" -> "draft" [label="revise"];
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteDOT() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFSM_WriteMermaid(t *testing.T) {
+	fsm := newExportFSM(t)
+
+	var b strings.Builder
+	if err := fsm.WriteMermaid(&b); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+
+	want := `stateDiagram-v2
+    [*] --> draft
+    draft --> submitted : submit
+    submitted --> approved : approve
+    submitted --> rejected : reject
+    approved --> published : publish
+    rejected --> draft : revise
+    published --> [*]
+    classDef initial font-weight:bold
+    class draft initial
+    classDef terminal stroke-width:3px
+    class published terminal
+`
+	if b.String() != want {
+		t.Errorf("WriteMermaid() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFSM_WriteMermaidAliases(t *testing.T) {
+	states := []State{{Name: "in review"}, {Name: "end"}, {Name: "s0"}}
+	events := []Event{{Name: "finish"}}
+	transitions := []Transition{
+		{From: State{Name: "in review"}, To: State{Name: "end"}, Event: Event{Name: "finish"}},
+	}
+	fsm, err := New(states, events, transitions, NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+
+	var b strings.Builder
+	if err := fsm.WriteMermaid(&b); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+
+	want := `stateDiagram-v2
+    state "in review" as s0_
+    state "end" as s1
+    s0_ --> s1 : finish
+`
+	if b.String() != want {
+		t.Errorf("WriteMermaid() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFSM_ExportWithTransitionCounts(t *testing.T) {
+	fsm := newExportFSM(t)
+	ctx := context.Background()
+
+	// Two documents: one rejected and revised, one published
+	doc1 := Entity{Type: "document", ID: "doc-1"}
+	doc2 := Entity{Type: "document", ID: "doc-2"}
+	for _, step := range []struct {
+		entity Entity
+		event  string
+	}{
+		{doc1, "submit"}, {doc1, "reject"}, {doc1, "revise"}, {doc1, "submit"},
+		{doc2, "submit"}, {doc2, "approve"}, {doc2, "publish"},
+	} {
+		if !fsm.CanTrigger(ctx, step.entity, Event{Name: step.event}) {
+			if err := fsm.Start(ctx, step.entity, State{Name: "draft"}, "user1"); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+		}
+		if err := fsm.Trigger(ctx, step.entity, Event{Name: step.event}, "user1"); err != nil {
+			t.Fatalf("Trigger(%v) error = %v", step.event, err)
+		}
+	}
+
+	counts, err := fsm.CountTransitions(ctx, doc1, doc2)
+	if err != nil {
+		t.Fatalf("CountTransitions() error = %v", err)
+	}
+	if got := counts[TransitionKey{From: "draft", Event: "submit", To: "submitted"}]; got != 3 {
+		t.Errorf("submit count = %v, want 3", got)
+	}
+
+	var b strings.Builder
+	if err := fsm.WriteMermaid(&b, WithTransitionCounts(counts)); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+	for _, want := range []string{
+		"[*] --> draft : (2)",
+		"draft --> submitted : submit (3)",
+		"submitted --> rejected : reject (1)",
+		"approved --> published : publish (1)",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteMermaid() missing %q in\n%s", want, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := fsm.WriteDOT(&b, WithTransitionCounts(counts)); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	if want := `"draft" -> "submitted" [label="submit (3)"];`; !strings.Contains(b.String(), want) {
+		t.Errorf("WriteDOT() missing %q in\n%s", want, b.String())
+	}
+}
+
+func TestFSM_CountTransitionsNotFound(t *testing.T) {
+	fsm := newExportFSM(t)
+
+	// Entities without history contribute nothing
+	counts, err := fsm.CountTransitions(context.Background(), Entity{Type: "document", ID: "missing"})
+	if err != nil {
+		t.Fatalf("CountTransitions() error = %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("CountTransitions() = %v, want empty", counts)
+	}
+}
@@ -2,6 +2,7 @@ package fsm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -11,16 +12,42 @@ var (
 	ErrInvalidState      = errors.New("invalid state")
 	ErrInvalidEvent      = errors.New("invalid event")
 	ErrInvalidTransition = errors.New("invalid transition")
+
+	// ErrConcurrentModification is returned when an entity was changed by
+	// another writer between reading its state and saving a transition.
+	// The operation can be retried.
+	ErrConcurrentModification = errors.New("concurrent modification")
+
+	// ErrEntityExists is returned by Start for an entity that has already been started
+	ErrEntityExists = errors.New("entity already exists")
+
+	// ErrTerminalState is returned by Trigger for an entity in a terminal state
+	ErrTerminalState = errors.New("entity is in a terminal state")
+
+	// ErrParallelState is returned by GetNextState for an event handled by
+	// the regions of a parallel state, whose outcome depends on the states
+	// the regions are in
+	ErrParallelState = errors.New("next state depends on the region states")
 )
 
 // State represents a state in the FSM
 type State struct {
 	Name string
+	// Parent names the composite state this state is nested in, if any. An
+	// event with no transition from a state is looked up on its parent, then
+	// on the parent's parent, so a transition declared once on a composite
+	// state applies to all of its substates. Entities are always in a leaf
+	// state or a parallel state: other composite states cannot be the target
+	// of a transition or an initial state.
+	Parent string
 }
 
 // Event represents an event that triggers a transition
 type Event struct {
 	Name string
+	// Payload carries JSON-serializable data for the event. It is available
+	// to guards and recorded in the transition history.
+	Payload map[string]any
 }
 
 // Transition defines how states change in response to events
@@ -30,6 +57,12 @@ type Transition struct {
 	Event     Event
 	CreatedAt time.Time
 	CreatedBy string
+	// Metadata holds arbitrary JSON-serializable data recorded with the
+	// transition, such as a reviewer's comment
+	Metadata map[string]any
+	// Guards must all pass for the transition to fire. When several
+	// transitions share From and Event, the first one whose guards pass wins.
+	Guards []Guard
 }
 
 // Entity represents something being tracked by the FSM
@@ -42,18 +75,40 @@ type Entity struct {
 type EntityState struct {
 	Entity Entity
 	State  State
+	// Version is the number of transitions recorded for the entity, which is
+	// the Sequence of the latest one, or 0 if the entity does not exist yet
+	Version int64
 }
 
 // EntityTransition represents a state transition for an entity
 type EntityTransition struct {
 	Entity     Entity
 	Transition Transition
+	// Sequence numbers the transitions of an entity 1, 2, 3, ... in the order
+	// they were saved. Storage assigns it on save, so an entity's version is
+	// the Sequence of its latest transition.
+	Sequence int64
+	// Data is the entity's extended state data after the transition, saved
+	// by storage implementing DataStorage; nil keeps the current data. It is
+	// not part of the history returned by GetTransitions.
+	Data json.RawMessage
+	// Regions holds, for a transition to a parallel state, the state of each
+	// of its regions after the transition, in the order they were declared.
+	// It is nil for other transitions. Storage implementing ParallelStorage
+	// saves it with the transition.
+	Regions []State
 }
 
 // Storage defines the interface for persisting FSM state
 type Storage interface {
 	SaveTransition(ctx context.Context, et EntityTransition) error
+	// CompareAndSaveTransition saves et only if the entity is still in
+	// expected.State at expected.Version. Otherwise it saves nothing and
+	// returns ErrConcurrentModification.
+	CompareAndSaveTransition(ctx context.Context, expected EntityState, et EntityTransition) error
 	GetCurrentState(ctx context.Context, entity Entity) (State, error)
+	// GetEntityState returns the current state and version of an entity
+	GetEntityState(ctx context.Context, entity Entity) (EntityState, error)
 	GetTransitions(ctx context.Context, entity Entity) ([]EntityTransition, error)
 }
 
@@ -63,10 +118,36 @@ type FSM struct {
 	events      []Event
 	transitions []Transition
 	storage     Storage
+	hooks       *hookSet
+	tree        *stateTree
+	parallel    []Parallel
+
+	initialStates  []State
+	terminalStates []State
+	substates      []State
+	strict         bool
+}
+
+// Option configures an FSM created by New
+type Option func(*FSM)
+
+// WithInitialStates declares the states entities can start in
+func WithInitialStates(states ...State) Option {
+	return func(f *FSM) {
+		f.initialStates = append(f.initialStates, states...)
+	}
+}
+
+// WithTerminalStates declares the final states of the workflow. The states
+// nested in a terminal composite state are terminal too.
+func WithTerminalStates(states ...State) Option {
+	return func(f *FSM) {
+		f.terminalStates = append(f.terminalStates, states...)
+	}
 }
 
 // New creates a new FSM instance
-func New(states []State, events []Event, transitions []Transition, storage Storage) (*FSM, error) {
+func New(states []State, events []Event, transitions []Transition, storage Storage, opts ...Option) (*FSM, error) {
 	if len(states) == 0 {
 		return nil, errors.New("no states defined")
 	}
@@ -96,66 +177,278 @@ func New(states []State, events []Event, transitions []Transition, storage Stora
 		}
 	}
 
-	return &FSM{
+	f := &FSM{
 		states:      states,
 		events:      events,
 		transitions: transitions,
 		storage:     storage,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	tree, err := newStateTree(states, f.substates)
+	if err != nil {
+		return nil, err
+	}
+	f.tree = tree
+	f.hooks = newHookSet()
+
+	if err := f.validateParallel(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range transitions {
+		if err := f.validateLeaf(t.To); err != nil {
+			return nil, fmt.Errorf("invalid to state in transition: %w", err)
+		}
+	}
+
+	for _, s := range f.initialStates {
+		if err := f.validateInitialState(s); err != nil {
+			return nil, fmt.Errorf("invalid initial state: %w", err)
+		}
+	}
+	for _, s := range f.terminalStates {
+		if err := validateState(s, states); err != nil {
+			return nil, fmt.Errorf("invalid terminal state: %w", err)
+		}
+	}
+
+	if f.strict {
+		for _, finding := range f.Lint() {
+			if finding.Severity == SeverityError {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidDefinition, finding.Message)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// WithStorage returns a copy of the FSM that reads and saves transitions
+// through storage. The copy shares the definition and hooks of f, including
+// hooks registered later. Combined with PostgresStorage.WithTx it runs Start,
+// Trigger and Reset inside a caller's transaction; enter and after-transition
+// hooks then run before that transaction commits.
+func (f *FSM) WithStorage(storage Storage) *FSM {
+	c := *f
+	c.storage = storage
+	return &c
 }
 
-// Start initializes an entity in the given state
-func (f *FSM) Start(ctx context.Context, entity Entity, initialState State, createdBy string) error {
-	if err := validateState(initialState, f.states); err != nil {
+// TriggerOption configures a single Start or Trigger call
+type TriggerOption func(*triggerOptions)
+
+type triggerOptions struct {
+	metadata map[string]any
+	payload  map[string]any
+	// data computes the entity's new data from its current data
+	data func(current json.RawMessage) (json.RawMessage, error)
+}
+
+// WithMetadata records metadata with the transition
+func WithMetadata(metadata map[string]any) TriggerOption {
+	return func(o *triggerOptions) {
+		o.metadata = metadata
+	}
+}
+
+// WithPayload sets the payload of the event passed to Trigger, for callers
+// that name the event without building an Event, such as Typed.Trigger
+func WithPayload(payload map[string]any) TriggerOption {
+	return func(o *triggerOptions) {
+		o.payload = payload
+	}
+}
+
+func applyTriggerOptions(opts []TriggerOption) triggerOptions {
+	var o triggerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Start initializes an entity in the given state and runs its enter hooks.
+// If initial states are declared, initialState must be one of them. Start
+// returns ErrEntityExists if the entity has already been started; use Reset
+// to start it over.
+func (f *FSM) Start(ctx context.Context, entity Entity, initialState State, createdBy string, opts ...TriggerOption) error {
+	if err := f.validateInitialState(initialState); err != nil {
 		return err
 	}
+	o := applyTriggerOptions(opts)
 
+	data, err := f.nextData(o, nil)
+	if err != nil {
+		return err
+	}
+
+	m := f.newMove(State{}, nil, initialState, Event{Name: "start"})
 	et := EntityTransition{
 		Entity: entity,
 		Transition: Transition{
 			From:      State{Name: ""},
 			To:        initialState,
-			Event:     Event{Name: "start"},
+			Event:     m.event,
 			CreatedAt: time.Now().UTC(),
 			CreatedBy: createdBy,
+			Metadata:  o.metadata,
 		},
+		Sequence: 1,
+		Data:     data,
+		Regions:  m.regions,
+	}
+
+	err = f.saveTransitions(ctx, EntityState{Entity: entity}, []EntityTransition{et})
+	if errors.Is(err, ErrConcurrentModification) {
+		return fmt.Errorf("%w: %s/%s", ErrEntityExists, entity.Type, entity.ID)
+	}
+	if err != nil {
+		return err
 	}
 
-	return f.storage.SaveTransition(ctx, et)
+	return f.hooks.runEnter(ctx, et, m.entered)
 }
 
-// Trigger attempts to trigger an event for an entity, causing a state transition
-func (f *FSM) Trigger(ctx context.Context, entity Entity, event Event, createdBy string) error {
-	// Get current state
-	currentState, err := f.storage.GetCurrentState(ctx, entity)
-	if err != nil {
+// Reset puts an entity into the given initial state, whether or not it has
+// been started before. Exit hooks of the current state run before the reset
+// is saved, and enter hooks of the new state after.
+func (f *FSM) Reset(ctx context.Context, entity Entity, initialState State, createdBy string, opts ...TriggerOption) error {
+	if err := f.validateInitialState(initialState); err != nil {
+		return err
+	}
+	o := applyTriggerOptions(opts)
+
+	current, currentData, err := f.getEntity(ctx, entity)
+	if err != nil && !errors.Is(err, ErrEntityNotFound) {
 		return fmt.Errorf("failed to get current state: %w", err)
 	}
+	if err != nil {
+		current = EntityState{Entity: entity}
+	}
 
-	// Validate event
-	if err := validateEvent(event, f.events); err != nil {
+	regions, err := f.getRegions(ctx, current)
+	if err != nil {
 		return err
 	}
 
-	// Find valid transition
-	nextState, err := f.findNextState(currentState, event)
+	data, err := f.nextData(o, currentData)
 	if err != nil {
 		return err
 	}
 
-	// Save transition
+	m := f.newMove(current.State, regions, initialState, Event{Name: "reset"})
 	et := EntityTransition{
 		Entity: entity,
 		Transition: Transition{
-			From:      currentState,
-			To:        nextState,
-			Event:     event,
+			From:      current.State,
+			To:        initialState,
+			Event:     m.event,
 			CreatedAt: time.Now().UTC(),
 			CreatedBy: createdBy,
+			Metadata:  o.metadata,
 		},
+		Sequence: current.Version + 1,
+		Data:     data,
+		Regions:  m.regions,
 	}
 
-	return f.storage.SaveTransition(ctx, et)
+	if err := f.hooks.runExit(ctx, et, m.exited); err != nil {
+		return err
+	}
+
+	if err := f.saveTransitions(ctx, current, []EntityTransition{et}); err != nil {
+		return err
+	}
+
+	return f.hooks.runEnter(ctx, et, m.entered)
+}
+
+// Trigger attempts to trigger an event for an entity, causing a state transition.
+// If another writer changes the entity concurrently, Trigger saves nothing and
+// returns ErrConcurrentModification.
+//
+// Before-transition and exit hooks run before the transition is saved and can
+// abort it; enter and after-transition hooks run once it has been saved.
+// See HookError for how hook failures are reported. An event that completes
+// the last region of a parallel state is saved together with the join it
+// causes, and a failing before-transition or exit hook of either aborts both.
+func (f *FSM) Trigger(ctx context.Context, entity Entity, event Event, createdBy string, opts ...TriggerOption) error {
+	o := applyTriggerOptions(opts)
+	if o.payload != nil {
+		event.Payload = o.payload
+	}
+
+	// Get current state
+	current, currentData, err := f.getEntity(ctx, entity)
+	if err != nil {
+		return fmt.Errorf("failed to get current state: %w", err)
+	}
+	currentState := current.State
+
+	if f.isTerminal(currentState) {
+		return fmt.Errorf("%w: %s/%s is in %q", ErrTerminalState, entity.Type, entity.ID, currentState.Name)
+	}
+
+	// Validate event
+	if err := validateEvent(event, f.events); err != nil {
+		return err
+	}
+
+	regions, err := f.getRegions(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	// Find valid transitions
+	moves, err := f.plan(ctx, entity, currentState, currentData, regions, event)
+	if err != nil {
+		return err
+	}
+
+	data, err := f.nextData(o, currentData)
+	if err != nil {
+		return err
+	}
+
+	ets := make([]EntityTransition, len(moves))
+	from := currentState
+	for i, m := range moves {
+		ets[i] = EntityTransition{
+			Entity: entity,
+			Transition: Transition{
+				From:      from,
+				To:        m.to,
+				Event:     m.event,
+				CreatedAt: time.Now().UTC(),
+				CreatedBy: createdBy,
+				Metadata:  o.metadata,
+			},
+			Sequence: current.Version + 1 + int64(i),
+			Data:     data,
+			Regions:  m.regions,
+		}
+		from = m.to
+	}
+
+	for i, m := range moves {
+		if err := f.hooks.runBeforeSave(ctx, ets[i], m.exited); err != nil {
+			return err
+		}
+	}
+
+	// Save transitions
+	if err := f.saveTransitions(ctx, current, ets); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, m := range moves {
+		errs = append(errs, f.hooks.runAfterSave(ctx, ets[i], m.entered))
+	}
+	return errors.Join(errs...)
 }
 
 // GetState returns the current state of an entity
@@ -168,44 +461,137 @@ func (f *FSM) GetTransitions(ctx context.Context, entity Entity) ([]EntityTransi
 	return f.storage.GetTransitions(ctx, entity)
 }
 
-// CanTrigger checks if an event can be triggered from the entity's current state
+// CanTrigger checks if an event can be triggered from the entity's current state,
+// including evaluating guards against the event's payload
 func (f *FSM) CanTrigger(ctx context.Context, entity Entity, event Event) bool {
-	currentState, err := f.storage.GetCurrentState(ctx, entity)
+	current, data, err := f.getEntity(ctx, entity)
+	if err != nil || f.isTerminal(current.State) {
+		return false
+	}
+
+	regions, err := f.getRegions(ctx, current)
 	if err != nil {
 		return false
 	}
 
-	_, err = f.findNextState(currentState, event)
+	_, err = f.plan(ctx, entity, current.State, data, regions, event)
 	return err == nil
 }
 
-// GetAvailableEvents returns all events that can be triggered from the entity's current state
+// GetAvailableEvents returns all events that can be triggered from the entity's current state.
+// Guards are evaluated without an event payload.
 func (f *FSM) GetAvailableEvents(ctx context.Context, entity Entity) ([]Event, error) {
-	currentState, err := f.storage.GetCurrentState(ctx, entity)
+	current, data, err := f.getEntity(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+	currentState := current.State
+	if f.isTerminal(currentState) {
+		return nil, nil
+	}
+
+	regions, err := f.getRegions(ctx, current)
 	if err != nil {
 		return nil, err
 	}
 
+	// In a parallel state the events of every region are available, as well
+	// as those leaving the parallel state
 	var events []Event
-	for _, t := range f.transitions {
-		if t.From.Name == currentState.Name {
-			events = append(events, t.Event)
+	seen := make(map[string]bool)
+	add := func(state State, lineage []string) {
+		for _, from := range lineage {
+			for _, t := range f.transitions {
+				if t.From.Name != from || seen[t.Event.Name] {
+					continue
+				}
+				in := GuardInput{Entity: entity, State: state, Data: data, Event: Event{Name: t.Event.Name}}
+				if checkGuards(ctx, t, in) != nil {
+					continue
+				}
+				seen[t.Event.Name] = true
+				events = append(events, Event{Name: t.Event.Name})
+			}
 		}
 	}
+	if p := f.parallelState(currentState.Name); p != nil {
+		for i, r := range p.Regions {
+			add(regions[i], f.regionLineage(regions[i].Name, r.State.Name))
+		}
+	}
+	add(currentState, f.tree.lineage(currentState.Name))
 
 	return events, nil
 }
 
-// GetNextState returns the next state for a given current state and event without triggering
+// GetNextState returns the next state for a given current state and event without triggering.
+// Guards are not evaluated.
+//
+// For a parallel state only the transitions leaving it are considered. An
+// event handled by one of its regions returns ErrParallelState, since the
+// regions' states decide what it does; ask for the next state of a region's
+// state instead, or use CanTrigger with the entity.
 func (f *FSM) GetNextState(currentState State, event Event) (State, error) {
 	return f.findNextState(currentState, event)
 }
 
-// findNextState finds the next state for a given state and event
+// resolveNextState finds the first transition for the given state and event
+// whose guards pass, trying the transitions of the state before those of its
+// ancestors. If every candidate is vetoed, the first veto is returned.
+func (f *FSM) resolveNextState(ctx context.Context, entity Entity, from State, data json.RawMessage, event Event) (State, error) {
+	in := GuardInput{Entity: entity, State: from, Data: data, Event: event}
+	to, ok, veto := f.resolve(ctx, in, f.tree.lineage(from.Name))
+	if ok {
+		return to, nil
+	}
+
+	if veto != nil {
+		return State{}, veto
+	}
+
+	return State{}, fmt.Errorf("%w: no transition from %q with event %q",
+		ErrInvalidTransition, from.Name, event.Name)
+}
+
+// resolve finds the first transition from one of names, in order, with the
+// event of in whose guards pass. If there is none, it returns the first
+// veto, which is nil if no transition matched the event.
+func (f *FSM) resolve(ctx context.Context, in GuardInput, names []string) (State, bool, error) {
+	var veto error
+	for _, name := range names {
+		for _, t := range f.transitions {
+			if t.From.Name != name || t.Event.Name != in.Event.Name {
+				continue
+			}
+			err := checkGuards(ctx, t, in)
+			if err == nil {
+				return t.To, true, nil
+			}
+			if veto == nil {
+				veto = err
+			}
+		}
+	}
+	return State{}, false, veto
+}
+
+// findNextState finds the next state for a given state and event, walking up
+// from the state through its ancestors
 func (f *FSM) findNextState(from State, event Event) (State, error) {
-	for _, t := range f.transitions {
-		if t.From.Name == from.Name && t.Event.Name == event.Name {
-			return t.To, nil
+	if f.parallelState(from.Name) != nil {
+		for _, t := range f.transitions {
+			if t.Event.Name == event.Name && t.From.Name != from.Name && f.tree.isWithin(t.From.Name, from.Name) {
+				return State{}, fmt.Errorf("%w: event %q is handled by the regions of parallel state %q",
+					ErrParallelState, event.Name, from.Name)
+			}
+		}
+	}
+
+	for _, name := range f.tree.lineage(from.Name) {
+		for _, t := range f.transitions {
+			if t.From.Name == name && t.Event.Name == event.Name {
+				return t.To, nil
+			}
 		}
 	}
 
@@ -213,6 +599,24 @@ func (f *FSM) findNextState(from State, event Event) (State, error) {
 		ErrInvalidTransition, from.Name, event.Name)
 }
 
+// validateInitialState checks that state is a valid leaf state and, if
+// initial states are declared, one of them
+func (f *FSM) validateInitialState(state State) error {
+	if err := validateState(state, f.states); err != nil {
+		return err
+	}
+	if err := f.validateLeaf(state); err != nil {
+		return err
+	}
+	if region := f.regionOf(state.Name); region != "" {
+		return fmt.Errorf("%w: state %q is in region %q of a parallel state", ErrInvalidState, state.Name, region)
+	}
+	if len(f.initialStates) > 0 && validateState(state, f.initialStates) != nil {
+		return fmt.Errorf("%w: state %q is not an initial state", ErrInvalidState, state.Name)
+	}
+	return nil
+}
+
 // Helper validation functions
 func validateState(state State, validStates []State) error {
 	for _, s := range validStates {
@@ -2,7 +2,11 @@ package fsm
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Test data - simple document approval workflow
@@ -422,3 +426,419 @@ func TestMemoryStorage_EntityNotFound(t *testing.T) {
 		t.Errorf("GetCurrentState() error = %v, want ErrEntityNotFound", err)
 	}
 }
+
+func TestMemoryStorage_CompareAndSaveTransition(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-cas"}
+	start := EntityTransition{
+		Entity:     entity,
+		Transition: Transition{To: State{Name: "draft"}, Event: Event{Name: "start"}},
+	}
+
+	// Entity must not exist yet
+	err := storage.CompareAndSaveTransition(ctx, EntityState{Entity: entity}, start)
+	if err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	// Creating it again conflicts
+	err = storage.CompareAndSaveTransition(ctx, EntityState{Entity: entity}, start)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition() error = %v, want ErrConcurrentModification", err)
+	}
+
+	current, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+	if current.State.Name != "draft" || current.Version != 1 {
+		t.Errorf("GetEntityState() = %v@%d, want draft@1", current.State.Name, current.Version)
+	}
+
+	submit := EntityTransition{
+		Entity:     entity,
+		Transition: Transition{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+	}
+
+	// Stale state is rejected
+	stale := EntityState{Entity: entity, State: State{Name: "submitted"}, Version: 1}
+	if err := storage.CompareAndSaveTransition(ctx, stale, submit); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale state) error = %v, want ErrConcurrentModification", err)
+	}
+
+	// Stale version is rejected
+	stale = EntityState{Entity: entity, State: State{Name: "draft"}, Version: 2}
+	if err := storage.CompareAndSaveTransition(ctx, stale, submit); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale version) error = %v, want ErrConcurrentModification", err)
+	}
+
+	if err := storage.CompareAndSaveTransition(ctx, current, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	transitions, _ := storage.GetTransitions(ctx, entity)
+	if len(transitions) != 2 {
+		t.Errorf("GetTransitions() count = %v, want 2", len(transitions))
+	}
+}
+
+// racingStorage simulates another writer that changes an entity right after
+// the FSM has read its state
+type racingStorage struct {
+	*MemoryStorage
+	race func()
+}
+
+func (r *racingStorage) GetEntityState(ctx context.Context, entity Entity) (EntityState, error) {
+	es, err := r.MemoryStorage.GetEntityState(ctx, entity)
+	if r.race != nil {
+		r.race()
+		r.race = nil
+	}
+	return es, err
+}
+
+func (r *racingStorage) GetEntityData(ctx context.Context, entity Entity) (EntityState, json.RawMessage, error) {
+	es, data, err := r.MemoryStorage.GetEntityData(ctx, entity)
+	if r.race != nil {
+		r.race()
+		r.race = nil
+	}
+	return es, data, err
+}
+
+func TestFSM_TriggerConcurrentModification(t *testing.T) {
+	storage := &racingStorage{MemoryStorage: NewMemoryStorage()}
+	fsm, err := New(testStates, testEvents, testTransitions, storage)
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-9"}
+	if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Another worker rejects the document while we are approving it
+	storage.race = func() {
+		storage.MemoryStorage.SaveTransition(ctx, EntityTransition{
+			Entity: entity,
+			Transition: Transition{
+				From:      State{Name: "submitted"},
+				To:        State{Name: "rejected"},
+				Event:     Event{Name: "reject"},
+				CreatedAt: time.Now().UTC(),
+				CreatedBy: "user2",
+			},
+		})
+	}
+
+	err = fsm.Trigger(ctx, entity, Event{Name: "approve"}, "user1")
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("Trigger() error = %v, want ErrConcurrentModification", err)
+	}
+
+	currentState, _ := fsm.GetState(ctx, entity)
+	if currentState.Name != "rejected" {
+		t.Errorf("GetState() = %v, want rejected", currentState.Name)
+	}
+}
+
+func TestFSM_TriggerConcurrent(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-10"}
+	if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Race approvals and rejections; only one may win
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		successes int
+	)
+	for i := 0; i < 20; i++ {
+		event := Event{Name: "approve"}
+		if i%2 == 1 {
+			event = Event{Name: "reject"}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := fsm.Trigger(ctx, entity, event, "worker")
+			if err == nil {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+				return
+			}
+			if !errors.Is(err, ErrConcurrentModification) && !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("Trigger() unexpected error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Errorf("successful triggers = %v, want 1", successes)
+	}
+
+	// History must form a consistent chain
+	transitions, _ := fsm.GetTransitions(ctx, entity)
+	for i := 1; i < len(transitions); i++ {
+		if transitions[i].Transition.From.Name != transitions[i-1].Transition.To.Name {
+			t.Errorf("transition %d from %v, previous to %v", i,
+				transitions[i].Transition.From.Name, transitions[i-1].Transition.To.Name)
+		}
+	}
+}
+
+func TestFSM_TriggerPayloadAndMetadata(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-11"}
+	err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1", WithMetadata(map[string]any{"source": "import"}))
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	event := Event{Name: "reject", Payload: map[string]any{"reason": "missing signature"}}
+	err = fsm.Trigger(ctx, entity, event, "user2", WithMetadata(map[string]any{"comment": "please sign page 3"}))
+	if err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	transitions, err := fsm.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(transitions) != 2 {
+		t.Fatalf("GetTransitions() count = %v, want 2", len(transitions))
+	}
+
+	if got := transitions[0].Transition.Metadata["source"]; got != "import" {
+		t.Errorf("start metadata source = %v, want import", got)
+	}
+	if got := transitions[1].Transition.Event.Payload["reason"]; got != "missing signature" {
+		t.Errorf("event payload reason = %v, want missing signature", got)
+	}
+	if got := transitions[1].Transition.Metadata["comment"]; got != "please sign page 3" {
+		t.Errorf("metadata comment = %v, want please sign page 3", got)
+	}
+}
+
+func TestMemoryStorage_PayloadAndMetadataCopies(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-12"}
+	et := EntityTransition{
+		Entity: entity,
+		Transition: Transition{
+			To:       State{Name: "rejected"},
+			Event:    Event{Name: "reject", Payload: map[string]any{"pages": []any{float64(2), float64(5)}}},
+			Metadata: map[string]any{"reviewer": map[string]any{"name": "alice"}},
+		},
+	}
+	if err := storage.SaveTransition(ctx, et); err != nil {
+		t.Fatalf("SaveTransition() error = %v", err)
+	}
+
+	check := func(when string) Transition {
+		t.Helper()
+		transitions, err := storage.GetTransitions(ctx, entity)
+		if err != nil {
+			t.Fatalf("GetTransitions() error = %v", err)
+		}
+		if len(transitions) != 1 {
+			t.Fatalf("GetTransitions() count = %v, want 1", len(transitions))
+		}
+		got := transitions[0].Transition
+		if pages := got.Event.Payload["pages"].([]any); pages[0] != float64(2) {
+			t.Errorf("%s: event payload pages = %v, want [2 5]", when, pages)
+		}
+		if name := got.Metadata["reviewer"].(map[string]any)["name"]; name != "alice" {
+			t.Errorf("%s: metadata reviewer name = %v, want alice", when, name)
+		}
+		return got
+	}
+
+	// Neither changing the maps saved nor those returned changes the history
+	et.Transition.Event.Payload["pages"].([]any)[0] = float64(0)
+	et.Transition.Metadata["reviewer"].(map[string]any)["name"] = "mallory"
+	got := check("after changing saved maps")
+	got.Event.Payload["pages"].([]any)[0] = float64(0)
+	got.Metadata["reviewer"].(map[string]any)["name"] = "mallory"
+	check("after changing returned maps")
+}
+
+func TestFSM_StartExistingEntity(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-12"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := fsm.Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1")
+	if !errors.Is(err, ErrEntityExists) {
+		t.Fatalf("Start() error = %v, want ErrEntityExists", err)
+	}
+
+	currentState, _ := fsm.GetState(ctx, entity)
+	if currentState.Name != "submitted" {
+		t.Errorf("GetState() = %v, want submitted", currentState.Name)
+	}
+}
+
+func TestFSM_Reset(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-13"}
+
+	// Reset works on entities that do not exist yet
+	if err := fsm.Reset(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if err := fsm.Reset(ctx, entity, State{Name: "draft"}, "admin"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	currentState, _ := fsm.GetState(ctx, entity)
+	if currentState.Name != "draft" {
+		t.Errorf("GetState() = %v, want draft", currentState.Name)
+	}
+
+	transitions, _ := fsm.GetTransitions(ctx, entity)
+	if len(transitions) != 2 {
+		t.Fatalf("GetTransitions() count = %v, want 2", len(transitions))
+	}
+	last := transitions[1].Transition
+	if last.From.Name != "submitted" || last.Event.Name != "reset" || last.CreatedBy != "admin" {
+		t.Errorf("reset transition = %+v, want submitted -> draft by admin", last)
+	}
+}
+
+func TestFSM_InitialAndTerminalStates(t *testing.T) {
+	// A transition out of published exists, but published is terminal
+	transitions := append([]Transition{
+		{From: State{Name: "published"}, To: State{Name: "draft"}, Event: Event{Name: "revise"}},
+	}, testTransitions...)
+
+	fsm, err := New(testStates, testEvents, transitions, NewMemoryStorage(),
+		WithInitialStates(State{Name: "draft"}),
+		WithTerminalStates(State{Name: "published"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-14"}
+
+	// Only declared initial states are accepted
+	err = fsm.Start(ctx, entity, State{Name: "approved"}, "user1")
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Start(approved) error = %v, want ErrInvalidState", err)
+	}
+	if err := fsm.Reset(ctx, entity, State{Name: "approved"}, "user1"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Reset(approved) error = %v, want ErrInvalidState", err)
+	}
+
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, event := range []string{"submit", "approve", "publish"} {
+		if err := fsm.Trigger(ctx, entity, Event{Name: event}, "user1"); err != nil {
+			t.Fatalf("Trigger(%v) error = %v", event, err)
+		}
+	}
+
+	// Published is terminal, so no further events are accepted
+	err = fsm.Trigger(ctx, entity, Event{Name: "revise"}, "user1")
+	if !errors.Is(err, ErrTerminalState) {
+		t.Errorf("Trigger() error = %v, want ErrTerminalState", err)
+	}
+	if fsm.CanTrigger(ctx, entity, Event{Name: "revise"}) {
+		t.Error("CanTrigger() = true for terminal entity, want false")
+	}
+	events, err := fsm.GetAvailableEvents(ctx, entity)
+	if err != nil || len(events) != 0 {
+		t.Errorf("GetAvailableEvents() = %v, %v, want none", events, err)
+	}
+}
+
+func TestFSM_WithStorage(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	other := NewMemoryStorage()
+	scoped := fsm.WithStorage(other)
+
+	// Hooks registered on the original FSM also run for the copy
+	entered := 0
+	mustRegister(t, fsm.OnEnter(State{Name: "submitted"}, func(ctx context.Context, et EntityTransition) error {
+		entered++
+		return nil
+	}))
+
+	entity := Entity{Type: "document", ID: "doc-15"}
+	if err := scoped.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := scoped.Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+	if entered != 1 {
+		t.Errorf("enter hook ran %d times, want 1", entered)
+	}
+
+	if state, err := other.GetCurrentState(ctx, entity); err != nil || state.Name != "submitted" {
+		t.Errorf("other.GetCurrentState() = %v, %v, want submitted", state.Name, err)
+	}
+	if _, err := fsm.GetState(ctx, entity); !errors.Is(err, ErrEntityNotFound) {
+		t.Errorf("GetState() on original storage error = %v, want ErrEntityNotFound", err)
+	}
+}
+
+func TestFSM_TransitionSequence(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	var hookSequences []int64
+	mustRegister(t, fsm.AfterTransition(Event{Name: "submit"}, func(ctx context.Context, et EntityTransition) error {
+		hookSequences = append(hookSequences, et.Sequence)
+		return nil
+	}))
+
+	entity := Entity{Type: "document", ID: "doc-16"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, event := range []string{"submit", "reject", "revise", "submit"} {
+		if err := fsm.Trigger(ctx, entity, Event{Name: event}, "user1"); err != nil {
+			t.Fatalf("Trigger(%v) error = %v", event, err)
+		}
+	}
+
+	history, _ := fsm.GetTransitions(ctx, entity)
+	for i, et := range history {
+		if et.Sequence != int64(i+1) {
+			t.Errorf("transition %d sequence = %v, want %v", i, et.Sequence, i+1)
+		}
+	}
+	if len(hookSequences) != 2 || hookSequences[0] != 2 || hookSequences[1] != 5 {
+		t.Errorf("after hook sequences = %v, want [2 5]", hookSequences)
+	}
+}
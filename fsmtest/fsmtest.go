@@ -0,0 +1,1024 @@
+// Package fsmtest provides a conformance test suite for fsm.Storage
+// implementations. A backend proves it is compatible by running the suite
+// from its own tests:
+//
+//	func TestStorageSuite(t *testing.T) {
+//		fsmtest.RunStorageSuite(t, func(t *testing.T) fsm.Storage {
+//			return NewMyStorage()
+//		})
+//	}
+package fsmtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fsm "github.com/tendant/simple-fsm"
+)
+
+// StorageFactory returns the storage a single test runs against. It may be
+// called once per test or return a shared instance; the suite uses entity IDs
+// unique to each run, so existing data does not interfere. Use t.Cleanup to
+// release resources.
+type StorageFactory func(t *testing.T) fsm.Storage
+
+// RunStorageSuite checks that the storage returned by newStorage honors the
+// fsm.Storage contract:
+//
+//   - unknown entities report fsm.ErrEntityNotFound and have no history
+//   - transitions are returned in the order they were saved, numbered by
+//     Sequence, and the current state and version reflect the latest one
+//   - entities with the same ID but a different type are independent
+//   - event payloads, metadata, creators and timestamps round-trip, and
+//     changing the maps passed to or returned by storage does not change
+//     the saved history
+//   - CompareAndSaveTransition rejects stale states and versions with
+//     fsm.ErrConcurrentModification, and exactly one concurrent writer wins
+//   - methods called with a cancelled context fail with context.Canceled
+//     and save nothing
+//
+// Optional interfaces such as fsm.StateQuerier are tested if the storage
+// implements them.
+func RunStorageSuite(t *testing.T, newStorage StorageFactory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s fsm.Storage)
+	}{
+		{"NotFound", testNotFound},
+		{"Ordering", testOrdering},
+		{"EntityIsolation", testEntityIsolation},
+		{"PayloadAndMetadata", testPayloadAndMetadata},
+		{"PayloadAndMetadataCopies", testPayloadAndMetadataCopies},
+		{"CompareAndSave", testCompareAndSave},
+		{"ConcurrentCompareAndSave", testConcurrentCompareAndSave},
+		{"ConcurrentEntities", testConcurrentEntities},
+		{"ContextCancellation", testContextCancellation},
+		{"FSM", testFSM},
+		{"QueryByState", testQueryByState},
+		{"StateAt", testStateAt},
+		{"History", testHistory},
+		{"Iterators", testIterators},
+		{"Data", testData},
+		{"Parallel", testParallel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+var (
+	runID     = time.Now().UnixNano()
+	entitySeq atomic.Int64
+)
+
+// newEntity returns an entity that no other test, or earlier run, has used
+func newEntity(entityType string) fsm.Entity {
+	return fsm.Entity{Type: entityType, ID: fmt.Sprintf("fsmtest-%d-%d", runID, entitySeq.Add(1))}
+}
+
+// baseTime and the offsets added to it are whole microseconds, the precision
+// of PostgreSQL timestamps
+var baseTime = time.Date(2025, 11, 4, 22, 0, 0, 0, time.UTC)
+
+func transition(entity fsm.Entity, from, to, event string, offset time.Duration) fsm.EntityTransition {
+	return fsm.EntityTransition{
+		Entity: entity,
+		Transition: fsm.Transition{
+			From:      fsm.State{Name: from},
+			To:        fsm.State{Name: to},
+			Event:     fsm.Event{Name: event},
+			CreatedBy: "fsmtest",
+			CreatedAt: baseTime.Add(offset),
+		},
+	}
+}
+
+func save(t *testing.T, s fsm.Storage, transitions ...fsm.EntityTransition) {
+	t.Helper()
+	for _, et := range transitions {
+		if err := s.SaveTransition(context.Background(), et); err != nil {
+			t.Fatalf("SaveTransition(%s) error = %v", et.Transition.Event.Name, err)
+		}
+	}
+}
+
+func wantState(t *testing.T, s fsm.Storage, entity fsm.Entity, state string, version int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	current, err := s.GetCurrentState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetCurrentState() error = %v", err)
+	}
+	if current.Name != state {
+		t.Errorf("GetCurrentState() = %q, want %q", current.Name, state)
+	}
+
+	es, err := s.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+	if es.State.Name != state || es.Version != version {
+		t.Errorf("GetEntityState() = %q@%d, want %q@%d", es.State.Name, es.Version, state, version)
+	}
+	if es.Entity != entity {
+		t.Errorf("GetEntityState() entity = %v, want %v", es.Entity, entity)
+	}
+}
+
+func testNotFound(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+	entity := newEntity("document")
+
+	if _, err := s.GetCurrentState(ctx, entity); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetCurrentState() error = %v, want ErrEntityNotFound", err)
+	}
+	if _, err := s.GetEntityState(ctx, entity); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetEntityState() error = %v, want ErrEntityNotFound", err)
+	}
+
+	history, err := s.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("GetTransitions() = %v, want no transitions", history)
+	}
+}
+
+func testOrdering(t *testing.T, s fsm.Storage) {
+	entity := newEntity("document")
+	saved := []fsm.EntityTransition{
+		transition(entity, "", "draft", "start", 0),
+		transition(entity, "draft", "submitted", "submit", time.Minute),
+		// Sequence numbers, not timestamps, order the history
+		transition(entity, "submitted", "rejected", "reject", time.Minute),
+		transition(entity, "rejected", "draft", "revise", -time.Hour),
+	}
+
+	for i, et := range saved {
+		save(t, s, et)
+		wantState(t, s, entity, et.Transition.To.Name, int64(i+1))
+	}
+
+	history, err := s.GetTransitions(context.Background(), entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != len(saved) {
+		t.Fatalf("GetTransitions() count = %d, want %d", len(history), len(saved))
+	}
+	for i, et := range history {
+		want := saved[i].Transition
+		got := et.Transition
+		if got.From.Name != want.From.Name || got.To.Name != want.To.Name || got.Event.Name != want.Event.Name {
+			t.Errorf("GetTransitions()[%d] = %s: %q -> %q, want %s: %q -> %q",
+				i, got.Event.Name, got.From.Name, got.To.Name, want.Event.Name, want.From.Name, want.To.Name)
+		}
+		if et.Entity != entity {
+			t.Errorf("GetTransitions()[%d] entity = %v, want %v", i, et.Entity, entity)
+		}
+		if et.Sequence != int64(i+1) {
+			t.Errorf("GetTransitions()[%d] sequence = %d, want %d", i, et.Sequence, i+1)
+		}
+	}
+}
+
+func testEntityIsolation(t *testing.T, s fsm.Storage) {
+	doc := newEntity("document")
+	order := fsm.Entity{Type: "order", ID: doc.ID}
+	other := newEntity("document")
+
+	save(t, s,
+		transition(doc, "", "draft", "start", 0),
+		transition(order, "", "pending", "start", 0),
+		transition(doc, "draft", "submitted", "submit", time.Minute),
+		transition(other, "", "approved", "start", 0),
+	)
+
+	wantState(t, s, doc, "submitted", 2)
+	wantState(t, s, order, "pending", 1)
+	wantState(t, s, other, "approved", 1)
+
+	for entity, want := range map[fsm.Entity]int{doc: 2, order: 1, other: 1} {
+		history, err := s.GetTransitions(context.Background(), entity)
+		if err != nil {
+			t.Fatalf("GetTransitions(%v) error = %v", entity, err)
+		}
+		if len(history) != want {
+			t.Errorf("GetTransitions(%v) count = %d, want %d", entity, len(history), want)
+		}
+	}
+}
+
+func testPayloadAndMetadata(t *testing.T, s fsm.Storage) {
+	entity := newEntity("document")
+
+	plain := transition(entity, "", "submitted", "start", 0)
+	rich := transition(entity, "submitted", "rejected", "reject", 1500*time.Millisecond)
+	rich.Transition.Event.Payload = map[string]any{"reason": "missing signature", "pages": float64(3)}
+	rich.Transition.Metadata = map[string]any{"comment": "please sign", "urgent": true}
+	save(t, s, plain, rich)
+
+	history, err := s.GetTransitions(context.Background(), entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("GetTransitions() count = %d, want 2", len(history))
+	}
+
+	first, second := history[0].Transition, history[1].Transition
+	if first.Event.Payload != nil || first.Metadata != nil {
+		t.Errorf("first transition payload = %v metadata = %v, want nil", first.Event.Payload, first.Metadata)
+	}
+	if second.Event.Payload["reason"] != "missing signature" || second.Event.Payload["pages"] != float64(3) {
+		t.Errorf("event payload = %v, want %v", second.Event.Payload, rich.Transition.Event.Payload)
+	}
+	if second.Metadata["comment"] != "please sign" || second.Metadata["urgent"] != true {
+		t.Errorf("metadata = %v, want %v", second.Metadata, rich.Transition.Metadata)
+	}
+	if second.CreatedBy != "fsmtest" {
+		t.Errorf("CreatedBy = %q, want fsmtest", second.CreatedBy)
+	}
+	if !second.CreatedAt.Equal(rich.Transition.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", second.CreatedAt, rich.Transition.CreatedAt)
+	}
+}
+
+func testPayloadAndMetadataCopies(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+	entity := newEntity("document")
+
+	newPayload := func() map[string]any {
+		return map[string]any{"reason": "missing signature", "pages": []any{float64(2), float64(5)}}
+	}
+	newMetadata := func() map[string]any {
+		return map[string]any{"reviewer": map[string]any{"name": "alice"}}
+	}
+
+	et := transition(entity, "", "rejected", "reject", 0)
+	et.Transition.Event.Payload = newPayload()
+	et.Transition.Metadata = newMetadata()
+	save(t, s, et)
+
+	// Changing the saved maps must not change the history
+	et.Transition.Event.Payload["reason"] = "changed"
+	et.Transition.Event.Payload["pages"].([]any)[0] = float64(0)
+	et.Transition.Metadata["reviewer"].(map[string]any)["name"] = "mallory"
+
+	check := func(when string) fsm.Transition {
+		t.Helper()
+		history, err := s.GetTransitions(ctx, entity)
+		if err != nil {
+			t.Fatalf("GetTransitions() error = %v", err)
+		}
+		if len(history) != 1 {
+			t.Fatalf("GetTransitions() count = %d, want 1", len(history))
+		}
+		got := history[0].Transition
+		if !reflect.DeepEqual(got.Event.Payload, newPayload()) {
+			t.Errorf("%s: event payload = %v, want %v", when, got.Event.Payload, newPayload())
+		}
+		if !reflect.DeepEqual(got.Metadata, newMetadata()) {
+			t.Errorf("%s: metadata = %v, want %v", when, got.Metadata, newMetadata())
+		}
+		return got
+	}
+
+	got := check("after changing saved maps")
+
+	// Neither must changing the maps returned
+	got.Event.Payload["reason"] = "changed"
+	got.Event.Payload["pages"].([]any)[0] = float64(0)
+	got.Metadata["reviewer"].(map[string]any)["name"] = "mallory"
+	check("after changing returned maps")
+}
+
+func testCompareAndSave(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+	entity := newEntity("document")
+	start := transition(entity, "", "draft", "start", 0)
+
+	// Version 0 expects the entity not to exist
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, start); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, start); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(existing entity) error = %v, want ErrConcurrentModification", err)
+	}
+	wantState(t, s, entity, "draft", 1)
+
+	submit := transition(entity, "draft", "submitted", "submit", time.Minute)
+
+	stale := fsm.EntityState{Entity: entity, State: fsm.State{Name: "submitted"}, Version: 1}
+	if err := s.CompareAndSaveTransition(ctx, stale, submit); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale state) error = %v, want ErrConcurrentModification", err)
+	}
+	ahead := fsm.EntityState{Entity: entity, State: fsm.State{Name: "draft"}, Version: 2}
+	if err := s.CompareAndSaveTransition(ctx, ahead, submit); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(future version) error = %v, want ErrConcurrentModification", err)
+	}
+
+	current := fsm.EntityState{Entity: entity, State: fsm.State{Name: "draft"}, Version: 1}
+	if err := s.CompareAndSaveTransition(ctx, current, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+	if err := s.CompareAndSaveTransition(ctx, current, submit); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale version) error = %v, want ErrConcurrentModification", err)
+	}
+	wantState(t, s, entity, "submitted", 2)
+
+	// SaveTransition appends after compare-and-save writes
+	save(t, s, transition(entity, "submitted", "approved", "approve", 2*time.Minute))
+	wantState(t, s, entity, "approved", 3)
+}
+
+func testConcurrentCompareAndSave(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+	entity := newEntity("document")
+	save(t, s, transition(entity, "", "submitted", "start", 0))
+	expected := fsm.EntityState{Entity: entity, State: fsm.State{Name: "submitted"}, Version: 1}
+
+	const writers = 10
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			to := "approved"
+			if i%2 == 1 {
+				to = "rejected"
+			}
+			errs[i] = s.CompareAndSaveTransition(ctx, expected, transition(entity, "submitted", to, "decide", time.Minute))
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, fsm.ErrConcurrentModification):
+			t.Errorf("CompareAndSaveTransition() error = %v, want nil or ErrConcurrentModification", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent writers succeeded, want 1", succeeded)
+	}
+
+	history, err := s.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("GetTransitions() count = %d, want 2", len(history))
+	}
+}
+
+func testConcurrentEntities(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+
+	const writers = 10
+	entities := make([]fsm.Entity, writers)
+	for i := range entities {
+		entities[i] = newEntity("document")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i, entity := range entities {
+		wg.Add(1)
+		go func(i int, entity fsm.Entity) {
+			defer wg.Done()
+			errs[i] = s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, transition(entity, "", "draft", "start", 0))
+			if errs[i] == nil {
+				errs[i] = s.SaveTransition(ctx, transition(entity, "draft", "submitted", "submit", time.Minute))
+			}
+		}(i, entity)
+	}
+	wg.Wait()
+
+	for i, entity := range entities {
+		if errs[i] != nil {
+			t.Errorf("writer %d error = %v", i, errs[i])
+			continue
+		}
+		wantState(t, s, entity, "submitted", 2)
+	}
+}
+
+func testContextCancellation(t *testing.T, s fsm.Storage) {
+	entity := newEntity("document")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	checks := map[string]func() error{
+		"SaveTransition": func() error {
+			return s.SaveTransition(ctx, transition(entity, "", "draft", "start", 0))
+		},
+		"CompareAndSaveTransition": func() error {
+			return s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, transition(entity, "", "draft", "start", 0))
+		},
+		"GetCurrentState": func() error {
+			_, err := s.GetCurrentState(ctx, entity)
+			return err
+		},
+		"GetEntityState": func() error {
+			_, err := s.GetEntityState(ctx, entity)
+			return err
+		},
+		"GetTransitions": func() error {
+			_, err := s.GetTransitions(ctx, entity)
+			return err
+		},
+	}
+	for name, call := range checks {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s() with cancelled context error = %v, want context.Canceled", name, err)
+		}
+	}
+
+	// Nothing was saved
+	if _, err := s.GetEntityState(context.Background(), entity); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetEntityState() error = %v, want ErrEntityNotFound", err)
+	}
+}
+
+func testFSM(t *testing.T, s fsm.Storage) {
+	ctx := context.Background()
+
+	states := []fsm.State{{Name: "draft"}, {Name: "submitted"}, {Name: "approved"}}
+	events := []fsm.Event{{Name: "submit"}, {Name: "approve"}}
+	transitions := []fsm.Transition{
+		{From: fsm.State{Name: "draft"}, To: fsm.State{Name: "submitted"}, Event: fsm.Event{Name: "submit"}},
+		{From: fsm.State{Name: "submitted"}, To: fsm.State{Name: "approved"}, Event: fsm.Event{Name: "approve"}},
+	}
+	machine, err := fsm.New(states, events, transitions, s)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	missing := newEntity("document")
+	if _, err := machine.GetState(ctx, missing); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetState(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+	if err := machine.Trigger(ctx, missing, fsm.Event{Name: "submit"}, "fsmtest"); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("Trigger(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+
+	entity := newEntity("document")
+	if err := machine.Start(ctx, entity, fsm.State{Name: "draft"}, "fsmtest"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Start(ctx, entity, fsm.State{Name: "draft"}, "fsmtest"); !errors.Is(err, fsm.ErrEntityExists) {
+		t.Errorf("Start(existing entity) error = %v, want ErrEntityExists", err)
+	}
+	if err := machine.Trigger(ctx, entity, fsm.Event{Name: "submit"}, "fsmtest"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+	if err := machine.Trigger(ctx, entity, fsm.Event{Name: "approve"}, "fsmtest"); err != nil {
+		t.Fatalf("Trigger(approve) error = %v", err)
+	}
+	wantState(t, s, entity, "approved", 3)
+
+	history, err := machine.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 3 || history[0].Transition.From.Name != "" || history[2].Transition.Event.Name != "approve" {
+		t.Errorf("GetTransitions() = %v, want start, submit and approve", history)
+	}
+}
+
+func testQueryByState(t *testing.T, s fsm.Storage) {
+	querier, ok := s.(fsm.StateQuerier)
+	if !ok {
+		t.Skip("storage does not implement fsm.StateQuerier")
+	}
+	ctx := context.Background()
+
+	// A type of its own, so entities from earlier runs are not listed. Each
+	// submitted entity enters the state later than the one before it.
+	entityType := fmt.Sprintf("fsmtest-invoice-%d-%d", runID, entitySeq.Add(1))
+	var submitted []fsm.Entity
+	for i := 0; i < 5; i++ {
+		entity := newEntity(entityType)
+		save(t, s,
+			transition(entity, "", "draft", "start", 0),
+			transition(entity, "draft", "submitted", "submit", time.Duration(i+1)*time.Minute),
+		)
+		submitted = append(submitted, entity)
+	}
+	draft := newEntity(entityType)
+	save(t, s, transition(draft, "", "draft", "start", 0))
+	approved := newEntity(entityType)
+	save(t, s,
+		transition(approved, "", "draft", "start", 0),
+		transition(approved, "draft", "submitted", "submit", time.Minute),
+		transition(approved, "submitted", "approved", "approve", 2*time.Minute),
+	)
+
+	query := func(q fsm.StateQuery) []fsm.EntityInState {
+		t.Helper()
+		q.Type = entityType
+		q.State = fsm.State{Name: "submitted"}
+
+		var all []fsm.EntityInState
+		for {
+			page, err := querier.QueryByState(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryByState() error = %v", err)
+			}
+			if q.Limit > 0 && len(page.Entities) > q.Limit {
+				t.Fatalf("QueryByState() returned %d entities, want at most %d", len(page.Entities), q.Limit)
+			}
+			all = append(all, page.Entities...)
+			if page.NextCursor == "" {
+				return all
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+	ids := func(entities []fsm.EntityInState) []string {
+		var ids []string
+		for _, e := range entities {
+			ids = append(ids, e.Entity.ID)
+		}
+		return ids
+	}
+
+	var byID []string
+	for _, e := range submitted {
+		byID = append(byID, e.ID)
+	}
+	slices.Sort(byID)
+	if got := ids(query(fsm.StateQuery{Limit: 2})); !slices.Equal(got, byID) {
+		t.Errorf("QueryByState(OrderByID) = %v, want %v", got, byID)
+	}
+
+	entered := query(fsm.StateQuery{Order: fsm.OrderByEnteredAt})
+	if len(entered) != len(submitted) {
+		t.Fatalf("QueryByState(OrderByEnteredAt) returned %d entities, want %d", len(entered), len(submitted))
+	}
+	for i, e := range entered {
+		if e.Entity != submitted[i] || e.State.Name != "submitted" || e.Version != 2 {
+			t.Errorf("QueryByState(OrderByEnteredAt)[%d] = %v %q@%d, want %v \"submitted\"@2", i, e.Entity, e.State.Name, e.Version, submitted[i])
+		}
+		if want := baseTime.Add(time.Duration(i+1) * time.Minute); !e.EnteredAt.Equal(want) {
+			t.Errorf("QueryByState(OrderByEnteredAt)[%d].EnteredAt = %v, want %v", i, e.EnteredAt, want)
+		}
+	}
+
+	newest := query(fsm.StateQuery{Order: fsm.OrderByEnteredAtDesc, Limit: 3})
+	var want []string
+	for i := len(submitted) - 1; i >= 0; i-- {
+		want = append(want, submitted[i].ID)
+	}
+	if got := ids(newest); !slices.Equal(got, want) {
+		t.Errorf("QueryByState(OrderByEnteredAtDesc) = %v, want %v", got, want)
+	}
+
+	page, err := querier.QueryByState(ctx, fsm.StateQuery{Type: entityType, State: fsm.State{Name: "approved"}})
+	if err != nil {
+		t.Fatalf("QueryByState(approved) error = %v", err)
+	}
+	if len(page.Entities) != 1 || page.Entities[0].Entity != approved || page.NextCursor != "" {
+		t.Errorf("QueryByState(approved) = %+v, want only %v", page, approved)
+	}
+
+	// A cursor cannot be reused with a different order
+	page, err = querier.QueryByState(ctx, fsm.StateQuery{Type: entityType, State: fsm.State{Name: "submitted"}, Limit: 1})
+	if err != nil {
+		t.Fatalf("QueryByState() error = %v", err)
+	}
+	_, err = querier.QueryByState(ctx, fsm.StateQuery{
+		Type:   entityType,
+		State:  fsm.State{Name: "submitted"},
+		Order:  fsm.OrderByEnteredAt,
+		Cursor: page.NextCursor,
+	})
+	if !errors.Is(err, fsm.ErrInvalidCursor) {
+		t.Errorf("QueryByState(cursor from another order) error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testStateAt(t *testing.T, s fsm.Storage) {
+	querier, ok := s.(fsm.StateAtQuerier)
+	if !ok {
+		t.Skip("storage does not implement fsm.StateAtQuerier")
+	}
+	ctx := context.Background()
+
+	entity := newEntity("contract")
+	save(t, s,
+		transition(entity, "", "draft", "start", 0),
+		transition(entity, "draft", "signed", "sign", 10*time.Minute),
+		transition(entity, "signed", "active", "activate", 10*time.Minute),
+		transition(entity, "active", "expired", "expire", time.Hour),
+	)
+
+	if _, err := querier.GetStateAt(ctx, entity, baseTime.Add(-time.Second)); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetStateAt(before start) error = %v, want ErrEntityNotFound", err)
+	}
+	if _, err := querier.GetStateAt(ctx, newEntity("contract"), baseTime); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetStateAt(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+
+	tests := []struct {
+		at      time.Duration
+		state   string
+		version int64
+	}{
+		{0, "draft", 1},
+		{5 * time.Minute, "draft", 1},
+		// Transitions with the same timestamp are ordered by sequence
+		{10 * time.Minute, "active", 3},
+		{59 * time.Minute, "active", 3},
+		{time.Hour, "expired", 4},
+		{24 * time.Hour, "expired", 4},
+	}
+	for _, tt := range tests {
+		es, err := querier.GetStateAt(ctx, entity, baseTime.Add(tt.at))
+		if err != nil {
+			t.Fatalf("GetStateAt(+%v) error = %v", tt.at, err)
+		}
+		if es.Entity != entity || es.State.Name != tt.state || es.Version != tt.version {
+			t.Errorf("GetStateAt(+%v) = %v %q@%d, want %q@%d", tt.at, es.Entity, es.State.Name, es.Version, tt.state, tt.version)
+		}
+	}
+
+	// The instant counts, not the time zone it is expressed in
+	es, err := querier.GetStateAt(ctx, entity, baseTime.Add(5*time.Minute).In(time.FixedZone("UTC+5", 5*60*60)))
+	if err != nil || es.State.Name != "draft" {
+		t.Errorf("GetStateAt(+5m in UTC+5) = %q, %v, want draft", es.State.Name, err)
+	}
+}
+
+func testHistory(t *testing.T, s fsm.Storage) {
+	querier, ok := s.(fsm.HistoryQuerier)
+	if !ok {
+		t.Skip("storage does not implement fsm.HistoryQuerier")
+	}
+	ctx := context.Background()
+
+	// Sequence n is created n-1 minutes after baseTime; alice submits and
+	// bob sends back
+	entity := newEntity("document")
+	save(t, s, transition(entity, "", "draft", "start", 0))
+	for i := 1; i < 7; i++ {
+		et := transition(entity, "draft", "submitted", "submit", time.Duration(i)*time.Minute)
+		et.Transition.CreatedBy = "alice"
+		if i%2 == 0 {
+			et = transition(entity, "submitted", "draft", "revise", time.Duration(i)*time.Minute)
+			et.Transition.CreatedBy = "bob"
+		}
+		save(t, s, et)
+	}
+
+	query := func(q fsm.HistoryQuery) []int64 {
+		t.Helper()
+		q.Entity = entity
+
+		var sequences []int64
+		for {
+			page, err := querier.QueryHistory(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryHistory() error = %v", err)
+			}
+			if q.Limit > 0 && len(page.Transitions) > q.Limit {
+				t.Fatalf("QueryHistory() returned %d transitions, want at most %d", len(page.Transitions), q.Limit)
+			}
+			for _, et := range page.Transitions {
+				if et.Entity != entity {
+					t.Fatalf("QueryHistory() entity = %v, want %v", et.Entity, entity)
+				}
+				sequences = append(sequences, et.Sequence)
+			}
+			if page.NextCursor == "" {
+				return sequences
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query fsm.HistoryQuery
+		want  []int64
+	}{
+		{"All", fsm.HistoryQuery{}, []int64{1, 2, 3, 4, 5, 6, 7}},
+		{"Paginated", fsm.HistoryQuery{Limit: 2}, []int64{1, 2, 3, 4, 5, 6, 7}},
+		{"Descending", fsm.HistoryQuery{Descending: true, Limit: 3}, []int64{7, 6, 5, 4, 3, 2, 1}},
+		{"TimeRange", fsm.HistoryQuery{Since: baseTime.Add(2 * time.Minute), Until: baseTime.Add(4 * time.Minute)}, []int64{3, 4}},
+		{"Since", fsm.HistoryQuery{Since: baseTime.Add(5 * time.Minute), Limit: 1}, []int64{6, 7}},
+		{"Events", fsm.HistoryQuery{Events: []fsm.Event{{Name: "start"}, {Name: "revise"}}}, []int64{1, 3, 5, 7}},
+		{"CreatedBy", fsm.HistoryQuery{CreatedBy: "alice", Descending: true, Limit: 1}, []int64{6, 4, 2}},
+		{"Combined", fsm.HistoryQuery{Events: []fsm.Event{{Name: "submit"}}, CreatedBy: "alice", Until: baseTime.Add(5 * time.Minute)}, []int64{2, 4}},
+		{"NoMatch", fsm.HistoryQuery{CreatedBy: "carol"}, nil},
+	}
+	for _, tt := range tests {
+		if got := query(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("QueryHistory(%s) sequences = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	page, err := querier.QueryHistory(ctx, fsm.HistoryQuery{Entity: entity, Limit: 1})
+	if err != nil {
+		t.Fatalf("QueryHistory() error = %v", err)
+	}
+	last := page.Transitions[0]
+	if last.Transition.Event.Name != "start" || last.Transition.To.Name != "draft" || !last.Transition.CreatedAt.Equal(baseTime) {
+		t.Errorf("QueryHistory()[0] = %+v, want start into draft at %v", last.Transition, baseTime)
+	}
+
+	// A cursor cannot be reused with a different order
+	_, err = querier.QueryHistory(ctx, fsm.HistoryQuery{Entity: entity, Descending: true, Cursor: page.NextCursor})
+	if !errors.Is(err, fsm.ErrInvalidCursor) {
+		t.Errorf("QueryHistory(cursor from another order) error = %v, want ErrInvalidCursor", err)
+	}
+
+	page, err = querier.QueryHistory(ctx, fsm.HistoryQuery{Entity: newEntity("document")})
+	if err != nil || len(page.Transitions) != 0 || page.NextCursor != "" {
+		t.Errorf("QueryHistory(unknown entity) = %+v, %v, want an empty page", page, err)
+	}
+}
+
+func testIterators(t *testing.T, s fsm.Storage) {
+	it, ok := s.(fsm.TransitionIterator)
+	if !ok {
+		t.Skip("storage does not implement fsm.TransitionIterator")
+	}
+	ctx := context.Background()
+
+	// A type of its own, so that transitions saved by other tests can be told
+	// apart in the global scan. The entities' transitions interleave in time.
+	entityType := fmt.Sprintf("fsmtest-scan-%d-%d", runID, entitySeq.Add(1))
+	a, b, c := newEntity(entityType), newEntity(entityType), newEntity(entityType)
+	save(t, s,
+		transition(a, "", "draft", "start", 0),
+		transition(b, "", "draft", "start", time.Minute),
+		transition(c, "", "draft", "start", 2*time.Minute),
+		transition(a, "draft", "submitted", "submit", 3*time.Minute),
+		transition(b, "draft", "submitted", "submit", 4*time.Minute),
+		transition(a, "submitted", "approved", "approve", 5*time.Minute),
+	)
+
+	var events []string
+	for et, err := range it.IterTransitions(ctx, a) {
+		if err != nil {
+			t.Fatalf("IterTransitions() error = %v", err)
+		}
+		if et.Entity != a || et.Sequence != int64(len(events)+1) {
+			t.Errorf("IterTransitions() yielded %v #%d, want %v #%d", et.Entity, et.Sequence, a, len(events)+1)
+		}
+		events = append(events, et.Transition.Event.Name)
+	}
+	if want := []string{"start", "submit", "approve"}; !slices.Equal(events, want) {
+		t.Errorf("IterTransitions() events = %v, want %v", events, want)
+	}
+
+	for _, err := range it.IterTransitions(ctx, newEntity(entityType)) {
+		t.Errorf("IterTransitions(unknown entity) yielded, error = %v", err)
+	}
+
+	// Stopping early
+	var n int
+	for range it.IterTransitions(ctx, a) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("IterTransitions() yielded %d transitions after break, want 1", n)
+	}
+
+	scan := func(since, until time.Time) []string {
+		t.Helper()
+		var got []string
+		var last time.Time
+		for et, err := range it.IterAllTransitions(ctx, since, until) {
+			if err != nil {
+				t.Fatalf("IterAllTransitions() error = %v", err)
+			}
+			if et.Transition.CreatedAt.Before(last) {
+				t.Fatalf("IterAllTransitions() yielded %v after %v", et.Transition.CreatedAt, last)
+			}
+			last = et.Transition.CreatedAt
+			if et.Entity.Type != entityType {
+				continue
+			}
+			name := map[fsm.Entity]string{a: "a", b: "b", c: "c"}[et.Entity]
+			got = append(got, fmt.Sprintf("%s%d", name, et.Sequence))
+		}
+		return got
+	}
+
+	if got, want := scan(baseTime, baseTime.Add(time.Hour)), []string{"a1", "b1", "c1", "a2", "b2", "a3"}; !slices.Equal(got, want) {
+		t.Errorf("IterAllTransitions() = %v, want %v", got, want)
+	}
+	if got, want := scan(baseTime.Add(time.Minute), baseTime.Add(4*time.Minute)), []string{"b1", "c1", "a2"}; !slices.Equal(got, want) {
+		t.Errorf("IterAllTransitions(1m, 4m) = %v, want %v", got, want)
+	}
+	if got := scan(time.Time{}, time.Time{}); len(got) != 6 {
+		t.Errorf("IterAllTransitions(open range) = %v, want all 6 transitions", got)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	var err error
+	for _, err = range it.IterAllTransitions(cancelled, time.Time{}, time.Time{}) {
+		break
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("IterAllTransitions(cancelled context) error = %v, want context.Canceled", err)
+	}
+}
+
+func testData(t *testing.T, s fsm.Storage) {
+	ds, ok := s.(fsm.DataStorage)
+	if !ok {
+		t.Skip("storage does not implement fsm.DataStorage")
+	}
+	ctx := context.Background()
+
+	wantData := func(entity fsm.Entity, state string, version int64, want string) {
+		t.Helper()
+		es, data, err := ds.GetEntityData(ctx, entity)
+		if err != nil {
+			t.Fatalf("GetEntityData() error = %v", err)
+		}
+		if es.Entity != entity || es.State.Name != state || es.Version != version {
+			t.Errorf("GetEntityData() = %v %q@%d, want %v %q@%d", es.Entity, es.State.Name, es.Version, entity, state, version)
+		}
+		if want == "" {
+			if data != nil {
+				t.Errorf("GetEntityData() data = %s, want nil", data)
+			}
+			return
+		}
+		var got, wantValue any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("GetEntityData() data = %s, not JSON: %v", data, err)
+		}
+		json.Unmarshal([]byte(want), &wantValue)
+		if !reflect.DeepEqual(got, wantValue) {
+			t.Errorf("GetEntityData() data = %s, want %s", data, want)
+		}
+	}
+
+	if _, _, err := ds.GetEntityData(ctx, newEntity("loan")); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetEntityData(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+
+	// Without data
+	plain := newEntity("loan")
+	save(t, s, transition(plain, "", "draft", "start", 0))
+	wantData(plain, "draft", 1, "")
+
+	entity := newEntity("loan")
+	start := transition(entity, "", "draft", "start", 0)
+	start.Data = json.RawMessage(`{"amount": 5000, "approvals": 0}`)
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, start); err != nil {
+		t.Fatalf("CompareAndSaveTransition(start) error = %v", err)
+	}
+	wantData(entity, "draft", 1, `{"amount": 5000, "approvals": 0}`)
+
+	// A rejected transition leaves the data alone
+	stale := transition(entity, "draft", "submitted", "submit", time.Minute)
+	stale.Data = json.RawMessage(`{"amount": 1}`)
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity, State: fsm.State{Name: "draft"}}, stale); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Fatalf("CompareAndSaveTransition(stale) error = %v, want ErrConcurrentModification", err)
+	}
+	wantData(entity, "draft", 1, `{"amount": 5000, "approvals": 0}`)
+
+	// nil data keeps the current data
+	submit := transition(entity, "draft", "submitted", "submit", time.Minute)
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity, State: fsm.State{Name: "draft"}, Version: 1}, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition(submit) error = %v", err)
+	}
+	wantData(entity, "submitted", 2, `{"amount": 5000, "approvals": 0}`)
+
+	approve := transition(entity, "submitted", "approved", "approve", 2*time.Minute)
+	approve.Data = json.RawMessage(`{"amount": 5000, "approvals": 1}`)
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity, State: fsm.State{Name: "submitted"}, Version: 2}, approve); err != nil {
+		t.Fatalf("CompareAndSaveTransition(approve) error = %v", err)
+	}
+	wantData(entity, "approved", 3, `{"amount": 5000, "approvals": 1}`)
+
+	// SaveTransition saves data as well
+	archive := transition(entity, "approved", "archived", "archive", 3*time.Minute)
+	archive.Data = json.RawMessage(`{"archived": true}`)
+	save(t, s, archive)
+	wantData(entity, "archived", 4, `{"archived": true}`)
+
+	history, err := s.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	for _, et := range history {
+		if et.Data != nil {
+			t.Errorf("GetTransitions()[%d].Data = %s, want nil", et.Sequence-1, et.Data)
+		}
+	}
+}
+
+func testParallel(t *testing.T, s fsm.Storage) {
+	ps, ok := s.(fsm.ParallelStorage)
+	if !ok {
+		t.Skip("storage does not implement fsm.ParallelStorage")
+	}
+	ctx := context.Background()
+
+	wantRegions := func(entity fsm.Entity, state string, version int64, want ...string) {
+		t.Helper()
+		es, regions, err := ps.GetEntityRegions(ctx, entity)
+		if err != nil {
+			t.Fatalf("GetEntityRegions() error = %v", err)
+		}
+		if es.Entity != entity || es.State.Name != state || es.Version != version {
+			t.Errorf("GetEntityRegions() = %v %q@%d, want %v %q@%d", es.Entity, es.State.Name, es.Version, entity, state, version)
+		}
+		if got := stateNames(regions); !reflect.DeepEqual(got, want) {
+			t.Errorf("GetEntityRegions() regions = %v, want %v", got, want)
+		}
+	}
+	withRegions := func(et fsm.EntityTransition, regions ...string) fsm.EntityTransition {
+		for _, r := range regions {
+			et.Regions = append(et.Regions, fsm.State{Name: r})
+		}
+		return et
+	}
+
+	if _, _, err := ps.GetEntityRegions(ctx, newEntity("loan")); !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetEntityRegions(unknown entity) error = %v, want ErrEntityNotFound", err)
+	}
+
+	entity := newEntity("loan")
+	save(t, s, transition(entity, "", "draft", "start", 0))
+	wantRegions(entity, "draft", 1)
+
+	submit := withRegions(transition(entity, "draft", "review", "submit", time.Minute), "docs/pending", "credit/pending")
+	if err := s.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity, State: fsm.State{Name: "draft"}, Version: 1}, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition(submit) error = %v", err)
+	}
+	wantRegions(entity, "review", 2, "docs/pending", "credit/pending")
+
+	// SaveTransition saves regions as well
+	save(t, s, withRegions(transition(entity, "review", "review", "verify", 2*time.Minute), "docs/verified", "credit/pending"))
+	wantRegions(entity, "review", 3, "docs/verified", "credit/pending")
+
+	// All transitions are saved, or none
+	inReview := fsm.EntityState{Entity: entity, State: fsm.State{Name: "review"}, Version: 3}
+	pass := withRegions(transition(entity, "review", "review", "pass", 3*time.Minute), "docs/verified", "credit/passed")
+	join := transition(entity, "review", "approved", "join", 3*time.Minute)
+	stale := fsm.EntityState{Entity: entity, State: fsm.State{Name: "review"}, Version: 2}
+	if err := ps.CompareAndSaveTransitions(ctx, stale, []fsm.EntityTransition{pass, join}); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransitions(stale version) error = %v, want ErrConcurrentModification", err)
+	}
+	wantRegions(entity, "review", 3, "docs/verified", "credit/pending")
+
+	if err := ps.CompareAndSaveTransitions(ctx, inReview, []fsm.EntityTransition{pass, join}); err != nil {
+		t.Fatalf("CompareAndSaveTransitions() error = %v", err)
+	}
+	wantRegions(entity, "approved", 5)
+
+	history, err := s.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	want := [][]string{nil, {"docs/pending", "credit/pending"}, {"docs/verified", "credit/pending"}, {"docs/verified", "credit/passed"}, nil}
+	if len(history) != len(want) {
+		t.Fatalf("GetTransitions() returned %d transitions, want %d", len(history), len(want))
+	}
+	for i, et := range history {
+		if et.Sequence != int64(i+1) {
+			t.Errorf("GetTransitions()[%d].Sequence = %d, want %d", i, et.Sequence, i+1)
+		}
+		if got := stateNames(et.Regions); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("GetTransitions()[%d].Regions = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func stateNames(states []fsm.State) []string {
+	var names []string
+	for _, s := range states {
+		names = append(names, s.Name)
+	}
+	return names
+}
@@ -0,0 +1,66 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Guard is a predicate attached to a Transition. It returns a non-nil error
+// to veto the transition; the error describes the reason.
+type Guard func(ctx context.Context, in GuardInput) error
+
+// GuardInput is the information available to a Guard
+type GuardInput struct {
+	Entity Entity
+	// State is the entity's current state
+	State State
+	// Data is the entity's current data, if the storage implements
+	// DataStorage; decode it with json.Unmarshal
+	Data json.RawMessage
+	// Event is the event being triggered, including its payload
+	Event Event
+}
+
+// GuardError is returned when a guard vetoes a transition. It wraps
+// ErrInvalidTransition and the error returned by the guard.
+type GuardError struct {
+	Entity Entity
+	From   State
+	To     State
+	Event  Event
+	Err    error
+}
+
+// Error implements the error interface
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("%v: transition from %q to %q with event %q vetoed by guard: %v",
+		ErrInvalidTransition, e.From.Name, e.To.Name, e.Event.Name, e.Err)
+}
+
+// Unwrap returns ErrInvalidTransition and the guard's error
+func (e *GuardError) Unwrap() []error {
+	return []error{ErrInvalidTransition, e.Err}
+}
+
+// Reason returns the guard's explanation for the veto
+func (e *GuardError) Reason() string {
+	return e.Err.Error()
+}
+
+// checkGuards evaluates the guards of t in order and returns a *GuardError
+// for the first veto
+func checkGuards(ctx context.Context, t Transition, in GuardInput) error {
+	for _, g := range t.Guards {
+		if err := g(ctx, in); err != nil {
+			return &GuardError{
+				Entity: in.Entity,
+				From:   t.From,
+				To:     t.To,
+				Event:  in.Event,
+				Err:    err,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errNotReviewer = errors.New("only reviewers can approve")
+
+// newGuardedFSM builds a workflow where approval requires a reviewer and
+// large documents are escalated instead of approved
+func newGuardedFSM(t *testing.T) *FSM {
+	isReviewer := func(ctx context.Context, in GuardInput) error {
+		if in.Event.Payload["role"] != "reviewer" {
+			return errNotReviewer
+		}
+		return nil
+	}
+	isLarge := func(ctx context.Context, in GuardInput) error {
+		if pages, _ := in.Event.Payload["pages"].(int); pages <= 100 {
+			return errors.New("document is not large")
+		}
+		return nil
+	}
+
+	states := []State{{Name: "submitted"}, {Name: "approved"}, {Name: "escalated"}, {Name: "rejected"}}
+	events := []Event{{Name: "approve"}, {Name: "reject"}}
+	transitions := []Transition{
+		{From: State{Name: "submitted"}, To: State{Name: "escalated"}, Event: Event{Name: "approve"}, Guards: []Guard{isReviewer, isLarge}},
+		{From: State{Name: "submitted"}, To: State{Name: "approved"}, Event: Event{Name: "approve"}, Guards: []Guard{isReviewer}},
+		{From: State{Name: "submitted"}, To: State{Name: "rejected"}, Event: Event{Name: "reject"}},
+	}
+
+	fsm, err := New(states, events, transitions, NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+	return fsm
+}
+
+func TestGuard_Veto(t *testing.T) {
+	fsm := newGuardedFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-1"}
+	if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	err := fsm.Trigger(ctx, entity, Event{Name: "approve", Payload: map[string]any{"role": "author"}}, "user1")
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Trigger() error = %v, want ErrInvalidTransition", err)
+	}
+	if !errors.Is(err, errNotReviewer) {
+		t.Errorf("Trigger() error = %v, want wrapped guard error", err)
+	}
+
+	var guardErr *GuardError
+	if !errors.As(err, &guardErr) {
+		t.Fatalf("Trigger() error = %T, want *GuardError", err)
+	}
+	if guardErr.Reason() != errNotReviewer.Error() {
+		t.Errorf("GuardError.Reason() = %q, want %q", guardErr.Reason(), errNotReviewer.Error())
+	}
+	if guardErr.Entity != entity || guardErr.From.Name != "submitted" || guardErr.Event.Name != "approve" {
+		t.Errorf("GuardError = %+v, missing transition details", guardErr)
+	}
+
+	// Nothing was saved
+	state, _ := fsm.GetState(ctx, entity)
+	if state.Name != "submitted" {
+		t.Errorf("GetState() = %v, want submitted", state.Name)
+	}
+}
+
+func TestGuard_FirstPassingTransitionWins(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]any
+		want    string
+	}{
+		{"small document", map[string]any{"role": "reviewer", "pages": 10}, "approved"},
+		{"large document", map[string]any{"role": "reviewer", "pages": 500}, "escalated"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsm := newGuardedFSM(t)
+			ctx := context.Background()
+
+			entity := Entity{Type: "document", ID: string(rune('a' + i))}
+			if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			if err := fsm.Trigger(ctx, entity, Event{Name: "approve", Payload: tt.payload}, "user1"); err != nil {
+				t.Fatalf("Trigger() error = %v", err)
+			}
+
+			state, _ := fsm.GetState(ctx, entity)
+			if state.Name != tt.want {
+				t.Errorf("GetState() = %v, want %v", state.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuard_CanTriggerAndAvailableEvents(t *testing.T) {
+	fsm := newGuardedFSM(t)
+	ctx := context.Background()
+
+	entity := Entity{Type: "document", ID: "doc-2"}
+	if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if fsm.CanTrigger(ctx, entity, Event{Name: "approve"}) {
+		t.Error("CanTrigger(approve) without reviewer role = true, want false")
+	}
+	if !fsm.CanTrigger(ctx, entity, Event{Name: "approve", Payload: map[string]any{"role": "reviewer"}}) {
+		t.Error("CanTrigger(approve) as reviewer = false, want true")
+	}
+
+	// Without a payload only the unguarded reject is available
+	events, err := fsm.GetAvailableEvents(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetAvailableEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "reject" {
+		t.Errorf("GetAvailableEvents() = %v, want [reject]", events)
+	}
+}
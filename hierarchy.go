@@ -0,0 +1,158 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithSubstates declares children as substates of the composite state parent,
+// the same as setting their Parent. Substates inherit the transitions of
+// their parent; see State.Parent.
+func WithSubstates(parent State, children ...State) Option {
+	return func(f *FSM) {
+		for _, c := range children {
+			f.substates = append(f.substates, State{Name: c.Name, Parent: parent.Name})
+		}
+	}
+}
+
+// stateTree records which states are nested in which
+type stateTree struct {
+	parent    map[string]string
+	composite map[string]bool
+}
+
+// newStateTree builds the tree declared by the Parent of states and by
+// substates, reporting undeclared parents, states with two parents and cycles
+func newStateTree(states, substates []State) (*stateTree, error) {
+	t := &stateTree{
+		parent:    make(map[string]string),
+		composite: make(map[string]bool),
+	}
+
+	for _, s := range append(states[:len(states):len(states)], substates...) {
+		if s.Parent == "" {
+			continue
+		}
+		if err := validateState(State{Name: s.Name}, states); err != nil {
+			return nil, fmt.Errorf("invalid substate: %w", err)
+		}
+		if err := validateState(State{Name: s.Parent}, states); err != nil {
+			return nil, fmt.Errorf("invalid parent of state %q: %w", s.Name, err)
+		}
+		if p, ok := t.parent[s.Name]; ok && p != s.Parent {
+			return nil, fmt.Errorf("%w: state %q has two parents, %q and %q", ErrInvalidState, s.Name, p, s.Parent)
+		}
+		t.parent[s.Name] = s.Parent
+		t.composite[s.Parent] = true
+	}
+
+	for name := range t.parent {
+		for p, steps := t.parent[name], 0; p != ""; p, steps = t.parent[p], steps+1 {
+			if p == name || steps > len(t.parent) {
+				return nil, fmt.Errorf("%w: state %q is nested in itself", ErrInvalidState, name)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// lineage returns name followed by its ancestors, innermost first
+func (t *stateTree) lineage(name string) []string {
+	names := []string{name}
+	for p := t.parent[name]; p != "" && len(names) <= len(t.parent); p = t.parent[p] {
+		names = append(names, p)
+	}
+	return names
+}
+
+// isComposite reports whether name has substates
+func (t *stateTree) isComposite(name string) bool {
+	return t.composite[name]
+}
+
+// isWithin reports whether name is ancestor or nested in it
+func (t *stateTree) isWithin(name, ancestor string) bool {
+	for _, n := range t.lineage(name) {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// exited returns the states left by a transition from one state to another,
+// innermost first: from and those of its ancestors that do not contain to.
+// It returns nothing for an empty from.
+func (t *stateTree) exited(from, to string) []string {
+	if from == "" {
+		return nil
+	}
+	names := []string{from}
+	for _, n := range t.lineage(from)[1:] {
+		if to != "" && t.isWithin(to, n) {
+			break
+		}
+		names = append(names, n)
+	}
+	return names
+}
+
+// entered returns the states entered by a transition from one state to
+// another, outermost first: those ancestors of to that do not contain from,
+// followed by to itself
+func (t *stateTree) entered(from, to string) []string {
+	exited := t.exited(to, from)
+	for i, j := 0, len(exited)-1; i < j; i, j = i+1, j-1 {
+		exited[i], exited[j] = exited[j], exited[i]
+	}
+	return exited
+}
+
+// validateLeaf checks that entities can rest in state, which must not be a
+// composite state other than a parallel state
+func (f *FSM) validateLeaf(state State) error {
+	if f.tree.isComposite(state.Name) && f.parallelState(state.Name) == nil {
+		return fmt.Errorf("%w: state %q is a composite state", ErrInvalidState, state.Name)
+	}
+	return nil
+}
+
+// GetStatePath returns the active state of an entity together with the
+// composite states containing it, outermost first. For an entity in
+// "fulfillment/picking", nested in "fulfillment", it returns
+// [fulfillment fulfillment/picking].
+func (f *FSM) GetStatePath(ctx context.Context, entity Entity) ([]State, error) {
+	state, err := f.storage.GetCurrentState(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+	return f.StatePath(state), nil
+}
+
+// StatePath returns state together with the composite states containing it,
+// outermost first
+func (f *FSM) StatePath(state State) []State {
+	lineage := f.tree.lineage(state.Name)
+	path := make([]State, len(lineage))
+	for i, name := range lineage {
+		path[len(lineage)-1-i] = State{Name: name, Parent: f.tree.parent[name]}
+	}
+	return path
+}
+
+// IsInState reports whether the entity is in state or in a state nested in
+// it. An entity in a parallel state is in the states of all of its regions.
+func (f *FSM) IsInState(ctx context.Context, entity Entity, state State) (bool, error) {
+	active, err := f.GetActiveStates(ctx, entity)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range active {
+		if f.tree.isWithin(s.Name, state.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
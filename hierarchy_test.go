@@ -0,0 +1,350 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFulfillmentFSM creates an order workflow whose fulfillment substates all
+// accept the cancel event declared on fulfillment
+func newFulfillmentFSM(t *testing.T, guards ...Guard) *FSM {
+	t.Helper()
+
+	states := []State{
+		{Name: "pending"},
+		{Name: "fulfillment"},
+		{Name: "fulfillment/picking", Parent: "fulfillment"},
+		{Name: "fulfillment/packing", Parent: "fulfillment"},
+		{Name: "shipped"},
+		{Name: "cancelled"},
+	}
+	events := []Event{{Name: "confirm"}, {Name: "pack"}, {Name: "ship"}, {Name: "cancel"}}
+	transitions := []Transition{
+		{From: State{Name: "pending"}, To: State{Name: "fulfillment/picking"}, Event: Event{Name: "confirm"}},
+		{From: State{Name: "fulfillment/picking"}, To: State{Name: "fulfillment/packing"}, Event: Event{Name: "pack"}},
+		{From: State{Name: "fulfillment/packing"}, To: State{Name: "shipped"}, Event: Event{Name: "ship"}},
+		{From: State{Name: "fulfillment/packing"}, To: State{Name: "shipped"}, Event: Event{Name: "cancel"}, Guards: guards},
+		{From: State{Name: "pending"}, To: State{Name: "cancelled"}, Event: Event{Name: "cancel"}},
+		{From: State{Name: "fulfillment"}, To: State{Name: "cancelled"}, Event: Event{Name: "cancel"}},
+	}
+
+	machine, err := New(states, events, transitions, NewMemoryStorage(),
+		WithInitialStates(State{Name: "pending"}),
+		WithTerminalStates(State{Name: "shipped"}, State{Name: "cancelled"}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return machine
+}
+
+func TestFSM_NestedStates(t *testing.T) {
+	ctx := context.Background()
+	veto := errors.New("already packed")
+	machine := newFulfillmentFSM(t, func(ctx context.Context, in GuardInput) error { return veto })
+
+	order := Entity{Type: "order", ID: "order-1"}
+	if err := machine.Start(ctx, order, State{Name: "pending"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, order, Event{Name: "confirm"}, "alice"); err != nil {
+		t.Fatalf("Trigger(confirm) error = %v", err)
+	}
+
+	events, err := machine.GetAvailableEvents(ctx, order)
+	if err != nil {
+		t.Fatalf("GetAvailableEvents() error = %v", err)
+	}
+	if want := []Event{{Name: "pack"}, {Name: "cancel"}}; !reflect.DeepEqual(events, want) {
+		t.Errorf("GetAvailableEvents() = %v, want %v", events, want)
+	}
+
+	path, err := machine.GetStatePath(ctx, order)
+	if err != nil {
+		t.Fatalf("GetStatePath() error = %v", err)
+	}
+	want := []State{{Name: "fulfillment"}, {Name: "fulfillment/picking", Parent: "fulfillment"}}
+	if !reflect.DeepEqual(path, want) {
+		t.Errorf("GetStatePath() = %v, want %v", path, want)
+	}
+
+	in, err := machine.IsInState(ctx, order, State{Name: "fulfillment"})
+	if err != nil || !in {
+		t.Errorf("IsInState(fulfillment) = %v, %v, want true", in, err)
+	}
+
+	if err := machine.Trigger(ctx, order, Event{Name: "pack"}, "bob"); err != nil {
+		t.Fatalf("Trigger(pack) error = %v", err)
+	}
+
+	// GetNextState ignores guards and finds the cancel declared on packing.
+	// Trigger finds it vetoed and falls back to the one on fulfillment.
+	if next, err := machine.GetNextState(State{Name: "fulfillment/packing"}, Event{Name: "cancel"}); err != nil || next.Name != "shipped" {
+		t.Errorf("GetNextState(packing, cancel) = %v, %v, want shipped", next, err)
+	}
+	if err := machine.Trigger(ctx, order, Event{Name: "cancel"}, "carol"); err != nil {
+		t.Fatalf("Trigger(cancel) error = %v", err)
+	}
+	state, err := machine.GetState(ctx, order)
+	if err != nil || state.Name != "cancelled" {
+		t.Errorf("GetState() = %v, %v, want cancelled", state, err)
+	}
+
+	history, err := machine.GetTransitions(ctx, order)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if from := history[len(history)-1].Transition.From.Name; from != "fulfillment/packing" {
+		t.Errorf("cancel recorded from %q, want fulfillment/packing", from)
+	}
+
+	in, err = machine.IsInState(ctx, order, State{Name: "fulfillment"})
+	if err != nil || in {
+		t.Errorf("IsInState(fulfillment) = %v, %v, want false", in, err)
+	}
+}
+
+func TestFSM_NestedStateHooks(t *testing.T) {
+	ctx := context.Background()
+	machine := newFulfillmentFSM(t)
+
+	var calls []string
+	record := func(name string) Hook {
+		return func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	mustRegister(t, machine.OnEnter(State{Name: "fulfillment"}, record("enter fulfillment")))
+	mustRegister(t, machine.OnExit(State{Name: "fulfillment"}, record("exit fulfillment")))
+	mustRegister(t, machine.OnEnter(State{Name: "fulfillment/picking"}, record("enter picking")))
+	mustRegister(t, machine.OnExit(State{Name: "fulfillment/picking"}, record("exit picking")))
+	mustRegister(t, machine.OnEnter(State{Name: "fulfillment/packing"}, record("enter packing")))
+	mustRegister(t, machine.OnExit(State{Name: "fulfillment/packing"}, record("exit packing")))
+
+	order := Entity{Type: "order", ID: "order-1"}
+	if err := machine.Start(ctx, order, State{Name: "pending"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, event := range []string{"confirm", "pack", "ship"} {
+		if err := machine.Trigger(ctx, order, Event{Name: event}, "alice"); err != nil {
+			t.Fatalf("Trigger(%s) error = %v", event, err)
+		}
+	}
+
+	want := []string{
+		"enter fulfillment", "enter picking",
+		"exit picking", "enter packing",
+		"exit packing", "exit fulfillment",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hook calls = %v, want %v", calls, want)
+	}
+}
+
+func TestFSM_TerminalCompositeState(t *testing.T) {
+	ctx := context.Background()
+	states := []State{{Name: "open"}, {Name: "closed"}, {Name: "closed/won", Parent: "closed"}, {Name: "closed/lost", Parent: "closed"}}
+	events := []Event{{Name: "win"}, {Name: "lose"}, {Name: "reopen"}}
+	transitions := []Transition{
+		{From: State{Name: "open"}, To: State{Name: "closed/won"}, Event: Event{Name: "win"}},
+		{From: State{Name: "open"}, To: State{Name: "closed/lost"}, Event: Event{Name: "lose"}},
+		{From: State{Name: "closed"}, To: State{Name: "open"}, Event: Event{Name: "reopen"}},
+	}
+	machine, err := New(states, events, transitions, NewMemoryStorage(), WithTerminalStates(State{Name: "closed"}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	deal := Entity{Type: "deal", ID: "deal-1"}
+	if err := machine.Start(ctx, deal, State{Name: "open"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, deal, Event{Name: "win"}, "alice"); err != nil {
+		t.Fatalf("Trigger(win) error = %v", err)
+	}
+	if err := machine.Trigger(ctx, deal, Event{Name: "reopen"}, "alice"); !errors.Is(err, ErrTerminalState) {
+		t.Errorf("Trigger(reopen) error = %v, want ErrTerminalState", err)
+	}
+}
+
+func TestNew_NestedStateErrors(t *testing.T) {
+	events := []Event{{Name: "go"}}
+	tests := []struct {
+		name        string
+		states      []State
+		transitions []Transition
+		opts        []Option
+		want        string
+	}{
+		{
+			name:        "undeclared parent",
+			states:      []State{{Name: "a"}, {Name: "b", Parent: "group"}},
+			transitions: []Transition{{From: State{Name: "a"}, To: State{Name: "b"}, Event: Event{Name: "go"}}},
+			want:        `invalid parent of state "b"`,
+		},
+		{
+			name:        "cycle",
+			states:      []State{{Name: "a", Parent: "b"}, {Name: "b", Parent: "a"}, {Name: "c"}},
+			transitions: []Transition{{From: State{Name: "c"}, To: State{Name: "c"}, Event: Event{Name: "go"}}},
+			want:        "is nested in itself",
+		},
+		{
+			name:        "two parents",
+			states:      []State{{Name: "a"}, {Name: "b"}, {Name: "c", Parent: "a"}},
+			transitions: []Transition{{From: State{Name: "a"}, To: State{Name: "c"}, Event: Event{Name: "go"}}},
+			opts:        []Option{WithSubstates(State{Name: "b"}, State{Name: "c"})},
+			want:        `state "c" has two parents`,
+		},
+		{
+			name:        "transition into composite state",
+			states:      []State{{Name: "a"}, {Name: "group"}, {Name: "b", Parent: "group"}},
+			transitions: []Transition{{From: State{Name: "a"}, To: State{Name: "group"}, Event: Event{Name: "go"}}},
+			want:        `invalid to state in transition: invalid state: state "group" is a composite state`,
+		},
+		{
+			name:        "composite initial state",
+			states:      []State{{Name: "a"}, {Name: "b"}},
+			transitions: []Transition{{From: State{Name: "a"}, To: State{Name: "b"}, Event: Event{Name: "go"}}},
+			opts:        []Option{WithSubstates(State{Name: "a"}, State{Name: "b"}), WithInitialStates(State{Name: "a"})},
+			want:        "invalid initial state",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.states, events, tt.transitions, NewMemoryStorage(), tt.opts...)
+			if !errors.Is(err, ErrInvalidState) {
+				t.Fatalf("New() error = %v, want ErrInvalidState", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSM_StartCompositeState(t *testing.T) {
+	machine := newFulfillmentFSM(t)
+	machine.initialStates = nil
+
+	err := machine.Start(context.Background(), Entity{Type: "order", ID: "order-1"}, State{Name: "fulfillment"}, "alice")
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Start() error = %v, want ErrInvalidState", err)
+	}
+}
+
+func TestLoadDefinition_NestedStates(t *testing.T) {
+	input := `name: order
+states:
+  - pending
+  - fulfillment
+  - {name: fulfillment/picking, parent: fulfillment}
+  - {name: fulfillment/packing, parent: fulfillment}
+  - shipped
+  - cancelled
+events: [confirm, pack, ship, cancel]
+initial: pending
+terminal: [shipped, cancelled]
+transitions:
+  - {from: pending, event: confirm, to: fulfillment/picking}
+  - {from: fulfillment/picking, event: pack, to: fulfillment/packing}
+  - {from: fulfillment/packing, event: ship, to: shipped}
+  - {from: fulfillment, event: cancel, to: cancelled}
+`
+	def, err := LoadDefinition(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+	if got := def.States[2]; got != (State{Name: "fulfillment/picking", Parent: "fulfillment"}) {
+		t.Errorf("States[2] = %v", got)
+	}
+	if findings := def.Lint(); len(findings) != 0 {
+		t.Errorf("Lint() = %v, want no findings", findings)
+	}
+
+	machine, err := def.New(NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	next, err := machine.GetNextState(State{Name: "fulfillment/packing"}, Event{Name: "cancel"})
+	if err != nil || next.Name != "cancelled" {
+		t.Errorf("GetNextState(packing, cancel) = %v, %v, want cancelled", next, err)
+	}
+}
+
+func TestLoadDefinition_NestedStateErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "undeclared parent",
+			input: `states: [a, {name: b, parent: group}]
+events: [go]
+transitions:
+  - {from: a, event: go, to: b}
+`,
+			want: `line 1, column 31: states[1].parent: invalid state: state "group" is not declared`,
+		},
+		{
+			name: "cycle",
+			input: `states: [a, {name: b, parent: c}, {name: c, parent: b}]
+events: [go]
+transitions:
+  - {from: a, event: go, to: a}
+`,
+			want: "is nested in itself",
+		},
+		{
+			name: "transition into composite state",
+			input: `states: [a, group, {name: b, parent: group}]
+events: [go]
+transitions:
+  - {from: a, event: go, to: group}
+`,
+			want: `line 4, column 30: transitions[0].to: invalid state: state "group" is a composite state`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadDefinition(strings.NewReader(tt.input))
+			if !errors.Is(err, ErrInvalidState) {
+				t.Fatalf("LoadDefinition() error = %v, want ErrInvalidState", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadDefinition() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSM_LintNestedStates(t *testing.T) {
+	machine := newFulfillmentFSM(t)
+	for _, f := range machine.Lint() {
+		if f.Code != FindingNondeterministic {
+			t.Errorf("unexpected finding %v", f)
+		}
+	}
+
+	var b strings.Builder
+	if err := machine.WriteMermaid(&b); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+	if !strings.Contains(b.String(), "    state fulfillment {\n        s2\n        s3\n    }\n") {
+		t.Errorf("WriteMermaid() has no composite state block:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := machine.WriteDOT(&b); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	if !strings.Contains(b.String(), "\tsubgraph \"cluster_fulfillment\" {\n\t\tlabel=\"fulfillment\";\n\t\t\"fulfillment\";\n\t\t\"fulfillment/picking\";\n") {
+		t.Errorf("WriteDOT() has no cluster:\n%s", b.String())
+	}
+}
@@ -0,0 +1,194 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Hook is a callback run around a state transition
+type Hook func(ctx context.Context, et EntityTransition) error
+
+// HookPhase identifies when a hook runs relative to saving a transition
+type HookPhase string
+
+const (
+	// PhaseBeforeTransition hooks run before the transition is saved
+	PhaseBeforeTransition HookPhase = "before_transition"
+	// PhaseExit hooks run before the transition is saved, after before-transition hooks
+	PhaseExit HookPhase = "exit"
+	// PhaseEnter hooks run after the transition is saved
+	PhaseEnter HookPhase = "enter"
+	// PhaseAfterTransition hooks run after the transition is saved, after enter hooks
+	PhaseAfterTransition HookPhase = "after_transition"
+)
+
+// HookError reports a failing hook.
+//
+// Errors from before-transition and exit hooks abort the transition: the first
+// failure is returned and nothing is saved. Enter and after-transition hooks
+// run only once the transition is saved; all of them run even if some fail,
+// and their errors are joined. Use Committed to tell the two cases apart.
+type HookError struct {
+	Phase      HookPhase
+	Entity     Entity
+	Transition Transition
+	Err        error
+}
+
+// Error implements the error interface
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed for transition from %q to %q with event %q: %v",
+		e.Phase, e.Transition.From.Name, e.Transition.To.Name, e.Transition.Event.Name, e.Err)
+}
+
+// Unwrap returns the error returned by the hook
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// Committed reports whether the transition was saved before the hook failed
+func (e *HookError) Committed() bool {
+	return e.Phase == PhaseEnter || e.Phase == PhaseAfterTransition
+}
+
+// hookSet holds the hooks registered on an FSM
+type hookSet struct {
+	mu     sync.RWMutex
+	before map[string][]Hook
+	exit   map[string][]Hook
+	enter  map[string][]Hook
+	after  map[string][]Hook
+}
+
+func newHookSet() *hookSet {
+	return &hookSet{
+		before: make(map[string][]Hook),
+		exit:   make(map[string][]Hook),
+		enter:  make(map[string][]Hook),
+		after:  make(map[string][]Hook),
+	}
+}
+
+func (h *hookSet) add(m map[string][]Hook, name string, hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m[name] = append(m[name], hook)
+}
+
+// runBeforeSave runs before-transition hooks and the exit hooks of the states
+// exited, stopping at the first failure
+func (h *hookSet) runBeforeSave(ctx context.Context, et EntityTransition, exited []string) error {
+	h.mu.RLock()
+	before := h.before[et.Transition.Event.Name]
+	h.mu.RUnlock()
+
+	if err := runHooks(ctx, PhaseBeforeTransition, before, et, true); err != nil {
+		return err
+	}
+	return h.runExit(ctx, et, exited)
+}
+
+// runExit runs the exit hooks of the states exited, in order, stopping at
+// the first failure
+func (h *hookSet) runExit(ctx context.Context, et EntityTransition, exited []string) error {
+	h.mu.RLock()
+	var exit []Hook
+	for _, name := range exited {
+		exit = append(exit, h.exit[name]...)
+	}
+	h.mu.RUnlock()
+
+	return runHooks(ctx, PhaseExit, exit, et, true)
+}
+
+// runAfterSave runs the enter hooks of the states entered and
+// after-transition hooks, joining all failures
+func (h *hookSet) runAfterSave(ctx context.Context, et EntityTransition, entered []string) error {
+	h.mu.RLock()
+	after := h.after[et.Transition.Event.Name]
+	h.mu.RUnlock()
+
+	return errors.Join(
+		h.runEnter(ctx, et, entered),
+		runHooks(ctx, PhaseAfterTransition, after, et, false),
+	)
+}
+
+// runEnter runs the enter hooks of the states entered, in order, joining all
+// failures
+func (h *hookSet) runEnter(ctx context.Context, et EntityTransition, entered []string) error {
+	h.mu.RLock()
+	var enter []Hook
+	for _, name := range entered {
+		enter = append(enter, h.enter[name]...)
+	}
+	h.mu.RUnlock()
+
+	return runHooks(ctx, PhaseEnter, enter, et, false)
+}
+
+func runHooks(ctx context.Context, phase HookPhase, hooks []Hook, et EntityTransition, stopOnError bool) error {
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(ctx, et); err != nil {
+			hookErr := &HookError{
+				Phase:      phase,
+				Entity:     et.Entity,
+				Transition: et.Transition,
+				Err:        err,
+			}
+			if stopOnError {
+				return hookErr
+			}
+			errs = append(errs, hookErr)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnEnter registers a hook that runs after an entity enters state.
+// It also runs when an entity is started or reset in state. The hooks of a
+// composite state run when an entity enters one of its substates from
+// outside it, and those of the regions of a parallel state and their initial
+// states when an entity enters the parallel state.
+func (f *FSM) OnEnter(state State, hook Hook) error {
+	if err := validateState(state, f.states); err != nil {
+		return err
+	}
+	f.hooks.add(f.hooks.enter, state.Name, hook)
+	return nil
+}
+
+// OnExit registers a hook that runs before an entity leaves state, including
+// by Reset. A failing hook aborts the transition. The hooks of a composite
+// state run when an entity leaves it from any of its substates, and those of
+// the active states of every region when an entity leaves a parallel state.
+func (f *FSM) OnExit(state State, hook Hook) error {
+	if err := validateState(state, f.states); err != nil {
+		return err
+	}
+	f.hooks.add(f.hooks.exit, state.Name, hook)
+	return nil
+}
+
+// BeforeTransition registers a hook that runs before a transition triggered by event
+// is saved. A failing hook aborts the transition.
+func (f *FSM) BeforeTransition(event Event, hook Hook) error {
+	if err := validateEvent(event, f.events); err != nil {
+		return err
+	}
+	f.hooks.add(f.hooks.before, event.Name, hook)
+	return nil
+}
+
+// AfterTransition registers a hook that runs after a transition triggered by event
+// has been saved
+func (f *FSM) AfterTransition(event Event, hook Hook) error {
+	if err := validateEvent(event, f.events); err != nil {
+		return err
+	}
+	f.hooks.add(f.hooks.after, event.Name, hook)
+	return nil
+}
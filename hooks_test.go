@@ -0,0 +1,134 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHooks_Order(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	var calls []string
+	record := func(name string) Hook {
+		return func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	mustRegister(t, fsm.BeforeTransition(Event{Name: "submit"}, record("before submit")))
+	mustRegister(t, fsm.OnExit(State{Name: "draft"}, record("exit draft")))
+	mustRegister(t, fsm.OnEnter(State{Name: "draft"}, record("enter draft")))
+	mustRegister(t, fsm.OnEnter(State{Name: "submitted"}, record("enter submitted")))
+	mustRegister(t, fsm.AfterTransition(Event{Name: "submit"}, record("after submit")))
+	mustRegister(t, fsm.AfterTransition(Event{Name: "approve"}, record("after approve")))
+
+	entity := Entity{Type: "document", ID: "doc-1"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := fsm.Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	want := []string{"enter draft", "before submit", "exit draft", "enter submitted", "after submit"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hook calls = %v, want %v", calls, want)
+	}
+}
+
+func TestHooks_BeforeHookAborts(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	errNoReviewer := errors.New("no reviewer assigned")
+	mustRegister(t, fsm.OnExit(State{Name: "draft"}, func(ctx context.Context, et EntityTransition) error {
+		return errNoReviewer
+	}))
+	afterRan := false
+	mustRegister(t, fsm.AfterTransition(Event{Name: "submit"}, func(ctx context.Context, et EntityTransition) error {
+		afterRan = true
+		return nil
+	}))
+
+	entity := Entity{Type: "document", ID: "doc-2"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	err := fsm.Trigger(ctx, entity, Event{Name: "submit"}, "user1")
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("Trigger() error = %v, want *HookError", err)
+	}
+	if hookErr.Phase != PhaseExit || hookErr.Committed() {
+		t.Errorf("HookError phase = %v committed = %v, want exit and not committed", hookErr.Phase, hookErr.Committed())
+	}
+	if !errors.Is(err, errNoReviewer) {
+		t.Errorf("Trigger() error = %v, want wrapped hook error", err)
+	}
+	if afterRan {
+		t.Error("after hook ran for aborted transition")
+	}
+
+	state, _ := fsm.GetState(ctx, entity)
+	if state.Name != "draft" {
+		t.Errorf("GetState() = %v, want draft", state.Name)
+	}
+}
+
+func TestHooks_AfterHookErrors(t *testing.T) {
+	fsm := newTestFSM(t)
+	ctx := context.Background()
+
+	errEmail := errors.New("smtp unavailable")
+	errIndex := errors.New("search index unavailable")
+	mustRegister(t, fsm.OnEnter(State{Name: "approved"}, func(ctx context.Context, et EntityTransition) error {
+		return errEmail
+	}))
+	mustRegister(t, fsm.AfterTransition(Event{Name: "approve"}, func(ctx context.Context, et EntityTransition) error {
+		return errIndex
+	}))
+
+	entity := Entity{Type: "document", ID: "doc-3"}
+	if err := fsm.Start(ctx, entity, State{Name: "submitted"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	err := fsm.Trigger(ctx, entity, Event{Name: "approve"}, "user2")
+	if !errors.Is(err, errEmail) || !errors.Is(err, errIndex) {
+		t.Fatalf("Trigger() error = %v, want both hook errors", err)
+	}
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || !hookErr.Committed() {
+		t.Errorf("Trigger() error = %v, want committed *HookError", err)
+	}
+
+	// The transition was saved despite the hook errors
+	state, _ := fsm.GetState(ctx, entity)
+	if state.Name != "approved" {
+		t.Errorf("GetState() = %v, want approved", state.Name)
+	}
+}
+
+func TestHooks_RegisterUnknown(t *testing.T) {
+	fsm := newTestFSM(t)
+	noop := func(ctx context.Context, et EntityTransition) error { return nil }
+
+	if err := fsm.OnEnter(State{Name: "aproved"}, noop); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("OnEnter() error = %v, want ErrInvalidState", err)
+	}
+	if err := fsm.BeforeTransition(Event{Name: "aprove"}, noop); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("BeforeTransition() error = %v, want ErrInvalidEvent", err)
+	}
+}
+
+func mustRegister(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("failed to register hook: %v", err)
+	}
+}
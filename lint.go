@@ -0,0 +1,241 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidDefinition is returned by New in strict mode when the definition
+// has error-level lint findings
+var ErrInvalidDefinition = errors.New("invalid definition")
+
+// Severity ranks how serious a Finding is
+type Severity int
+
+const (
+	// SeverityWarning marks a likely mistake that does not break the FSM
+	SeverityWarning Severity = iota + 1
+	// SeverityError marks a definition whose behavior is ambiguous
+	SeverityError
+)
+
+// String returns "warning" or "error"
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// FindingCode identifies the kind of problem a Finding reports
+type FindingCode string
+
+const (
+	// FindingUnreachable reports a state no initial state can lead to
+	FindingUnreachable FindingCode = "unreachable"
+	// FindingDeadEnd reports a non-terminal state with no outgoing transitions
+	FindingDeadEnd FindingCode = "dead_end"
+	// FindingNondeterministic reports several transitions sharing a from-state and event
+	FindingNondeterministic FindingCode = "nondeterministic"
+)
+
+// Finding is a problem reported by Lint
+type Finding struct {
+	Severity Severity
+	Code     FindingCode
+	State    string
+	// Event is set for nondeterministic findings
+	Event   string
+	Message string
+}
+
+// String formats the finding as "severity: code: message"
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Code, f.Message)
+}
+
+// WithStrict makes New reject definitions with error-level lint findings,
+// such as an unguarded transition followed by another with the same
+// from-state and event
+func WithStrict() Option {
+	return func(f *FSM) {
+		f.strict = true
+	}
+}
+
+// Lint analyzes the FSM definition and reports:
+//
+//   - nondeterministic transitions: several transitions with the same from-state
+//     and event. This is an error when an unguarded one comes before any other,
+//     since the transitions after it can never fire, and a warning otherwise,
+//     since the outcome depends on guard order.
+//   - unreachable states: states that cannot be reached from any initial state.
+//     Only checked when initial states are declared.
+//   - dead ends: states without outgoing transitions that are not declared terminal.
+//
+// A substate has the outgoing transitions of its ancestors, and a composite
+// state is reached when one of its substates is. Entering a parallel state
+// reaches the initial states of its regions and its join state, and the
+// final states of a region are not dead ends.
+func (f *FSM) Lint() []Finding {
+	return lint(f.states, f.transitions, f.initialStates, f.terminalStates, f.tree, f.parallel)
+}
+
+// Lint analyzes the definition; see FSM.Lint
+func (d *Definition) Lint() []Finding {
+	var substates []State
+	for _, p := range d.Parallel {
+		substates = append(substates, p.substates()...)
+	}
+	tree, err := newStateTree(d.States, substates)
+	if err != nil {
+		tree = &stateTree{}
+	}
+	return lint(d.States, d.Transitions, d.Initial, d.Terminal, tree, d.Parallel)
+}
+
+func lint(states []State, transitions []Transition, initial, terminal []State, tree *stateTree, parallel []Parallel) []Finding {
+	// A parallel state leads to the initial states of its regions and to its
+	// join state, and the final states of its regions need no way out
+	edges := transitions[:len(transitions):len(transitions)]
+	done := terminal[:len(terminal):len(terminal)]
+	for _, p := range parallel {
+		for _, r := range p.Regions {
+			edges = append(edges, Transition{From: p.State, To: r.Initial})
+			done = append(done, r.Final...)
+		}
+		if p.Join.Name != "" {
+			edges = append(edges, Transition{From: p.State, To: p.Join})
+		}
+	}
+
+	var findings []Finding
+	findings = append(findings, lintNondeterminism(transitions)...)
+	findings = append(findings, lintUnreachable(states, edges, initial, tree)...)
+	findings = append(findings, lintDeadEnds(states, transitions, done, tree)...)
+	return findings
+}
+
+func lintNondeterminism(transitions []Transition) []Finding {
+	type key struct{ from, event string }
+
+	var order []key
+	groups := make(map[key][]Transition)
+	for _, t := range transitions {
+		k := key{t.From.Name, t.Event.Name}
+		if groups[k] == nil {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], t)
+	}
+
+	var findings []Finding
+	for _, k := range order {
+		group := groups[k]
+		if len(group) < 2 {
+			continue
+		}
+
+		// An unguarded transition always fires, so none after it can
+		shadowing := -1
+		targets := make([]string, len(group))
+		for i, t := range group {
+			if len(t.Guards) == 0 && shadowing < 0 && i < len(group)-1 {
+				shadowing = i
+			}
+			targets[i] = fmt.Sprintf("%q", t.To.Name)
+		}
+
+		finding := Finding{
+			Severity: SeverityWarning,
+			Code:     FindingNondeterministic,
+			State:    k.from,
+			Event:    k.event,
+			Message: fmt.Sprintf("event %q from state %q has %d transitions (to %s); the first whose guards pass fires",
+				k.event, k.from, len(group), strings.Join(targets, ", ")),
+		}
+		if shadowing >= 0 {
+			finding.Severity = SeverityError
+			finding.Message = fmt.Sprintf("event %q from state %q has %d transitions (to %s); the unguarded transition to %q always fires, so those after it never do",
+				k.event, k.from, len(group), strings.Join(targets, ", "), group[shadowing].To.Name)
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+func lintUnreachable(states []State, transitions []Transition, initial []State, tree *stateTree) []Finding {
+	if len(initial) == 0 {
+		return nil
+	}
+
+	reached := make(map[string]bool)
+	queue := make([]string, 0, len(initial))
+	for _, s := range initial {
+		if !reached[s.Name] {
+			reached[s.Name] = true
+			queue = append(queue, s.Name)
+		}
+	}
+	for len(queue) > 0 {
+		lineage := tree.lineage(queue[0])
+		queue = queue[1:]
+		for _, name := range lineage[1:] {
+			reached[name] = true
+		}
+		for _, from := range lineage {
+			for _, t := range transitions {
+				if t.From.Name == from && !reached[t.To.Name] {
+					reached[t.To.Name] = true
+					queue = append(queue, t.To.Name)
+				}
+			}
+		}
+	}
+
+	var findings []Finding
+	for _, s := range states {
+		if !reached[s.Name] {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Code:     FindingUnreachable,
+				State:    s.Name,
+				Message:  fmt.Sprintf("state %q cannot be reached from any initial state", s.Name),
+			})
+		}
+	}
+	return findings
+}
+
+func lintDeadEnds(states []State, transitions []Transition, terminal []State, tree *stateTree) []Finding {
+	// ok holds the states with outgoing transitions or declared terminal;
+	// their substates inherit both
+	ok := make(map[string]bool)
+	for _, t := range transitions {
+		ok[t.From.Name] = true
+	}
+	for _, s := range terminal {
+		ok[s.Name] = true
+	}
+	inherited := func(name string) bool { return ok[name] }
+
+	var findings []Finding
+	for _, s := range states {
+		if tree.isComposite(s.Name) || slices.ContainsFunc(tree.lineage(s.Name), inherited) {
+			continue
+		}
+		findings = append(findings, Finding{
+			Severity: SeverityWarning,
+			Code:     FindingDeadEnd,
+			State:    s.Name,
+			Message:  fmt.Sprintf("state %q has no outgoing transitions and is not terminal", s.Name),
+		})
+	}
+	return findings
+}
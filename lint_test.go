@@ -0,0 +1,136 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFSM_Lint(t *testing.T) {
+	allow := func(ctx context.Context, in GuardInput) error { return nil }
+
+	states := []State{
+		{Name: "draft"}, {Name: "submitted"}, {Name: "approved"}, {Name: "escalated"},
+		{Name: "rejected"}, {Name: "archived"}, {Name: "published"},
+	}
+	events := []Event{{Name: "submit"}, {Name: "approve"}, {Name: "reject"}, {Name: "publish"}, {Name: "archive"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+		// Guarded alternatives
+		{From: State{Name: "submitted"}, To: State{Name: "escalated"}, Event: Event{Name: "approve"}, Guards: []Guard{allow}},
+		{From: State{Name: "submitted"}, To: State{Name: "approved"}, Event: Event{Name: "approve"}},
+		// Two unguarded transitions for the same event
+		{From: State{Name: "submitted"}, To: State{Name: "rejected"}, Event: Event{Name: "reject"}},
+		{From: State{Name: "submitted"}, To: State{Name: "draft"}, Event: Event{Name: "reject"}},
+		{From: State{Name: "approved"}, To: State{Name: "published"}, Event: Event{Name: "publish"}},
+		{From: State{Name: "escalated"}, To: State{Name: "approved"}, Event: Event{Name: "approve"}},
+		{From: State{Name: "rejected"}, To: State{Name: "draft"}, Event: Event{Name: "submit"}},
+		// Nothing leads to archived
+		{From: State{Name: "archived"}, To: State{Name: "draft"}, Event: Event{Name: "archive"}},
+	}
+
+	fsm, err := New(states, events, transitions, NewMemoryStorage(),
+		WithInitialStates(State{Name: "draft"}),
+		WithTerminalStates(State{Name: "published"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create FSM: %v", err)
+	}
+
+	type result struct {
+		Severity Severity
+		Code     FindingCode
+		State    string
+		Event    string
+	}
+	var got []result
+	for _, f := range fsm.Lint() {
+		if f.Message == "" {
+			t.Errorf("finding %+v has no message", f)
+		}
+		got = append(got, result{f.Severity, f.Code, f.State, f.Event})
+	}
+
+	want := []result{
+		{SeverityWarning, FindingNondeterministic, "submitted", "approve"},
+		{SeverityError, FindingNondeterministic, "submitted", "reject"},
+		{SeverityWarning, FindingUnreachable, "archived", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint() = %v, want %v", got, want)
+	}
+}
+
+func TestFSM_LintUnguardedFirst(t *testing.T) {
+	allow := func(ctx context.Context, in GuardInput) error { return nil }
+
+	// The guarded transition after the unguarded one can never fire
+	transitions := append([]Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+		{From: State{Name: "draft"}, To: State{Name: "rejected"}, Event: Event{Name: "submit"}, Guards: []Guard{allow}},
+	}, testTransitions[1:]...)
+
+	fsm, err := New(testStates, testEvents, transitions, NewMemoryStorage(), WithTerminalStates(State{Name: "published"}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	findings := fsm.Lint()
+	if len(findings) != 1 || findings[0].Severity != SeverityError || findings[0].Code != FindingNondeterministic || findings[0].State != "draft" {
+		t.Errorf("Lint() = %v, want a nondeterministic error for draft", findings)
+	}
+
+	_, err = New(testStates, testEvents, transitions, NewMemoryStorage(), WithStrict())
+	if !errors.Is(err, ErrInvalidDefinition) {
+		t.Errorf("New(WithStrict) error = %v, want ErrInvalidDefinition", err)
+	}
+}
+
+func TestFSM_LintDeadEnds(t *testing.T) {
+	// Without declared terminal states, published is a dead end
+	fsm := newTestFSM(t)
+	findings := fsm.Lint()
+	if len(findings) != 1 || findings[0].Code != FindingDeadEnd || findings[0].State != "published" {
+		t.Errorf("Lint() = %v, want dead end for published", findings)
+	}
+
+	// Declaring it terminal clears the finding
+	fsm = newExportFSM(t)
+	if findings := fsm.Lint(); len(findings) != 0 {
+		t.Errorf("Lint() = %v, want no findings", findings)
+	}
+}
+
+func TestNew_Strict(t *testing.T) {
+	transitions := append([]Transition{
+		{From: State{Name: "draft"}, To: State{Name: "rejected"}, Event: Event{Name: "submit"}},
+	}, testTransitions...)
+
+	// Accepted by default
+	if _, err := New(testStates, testEvents, transitions, NewMemoryStorage()); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err := New(testStates, testEvents, transitions, NewMemoryStorage(), WithStrict())
+	if !errors.Is(err, ErrInvalidDefinition) {
+		t.Errorf("New(WithStrict) error = %v, want ErrInvalidDefinition", err)
+	}
+
+	// Warnings are not rejected
+	if _, err := New(testStates, testEvents, testTransitions, NewMemoryStorage(), WithStrict()); err != nil {
+		t.Errorf("New(WithStrict) error = %v, want nil", err)
+	}
+}
+
+func TestDefinition_Lint(t *testing.T) {
+	def := &Definition{
+		States:      testStates,
+		Events:      testEvents,
+		Transitions: testTransitions,
+		Initial:     []State{{Name: "draft"}},
+		Terminal:    []State{{Name: "published"}},
+	}
+	if findings := def.Lint(); len(findings) != 0 {
+		t.Errorf("Lint() = %v, want no findings", findings)
+	}
+}
@@ -0,0 +1,202 @@
+package fsm
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationFS holds the goose migrations in migrations/. New schema changes
+// are added there as goose files whose Up section is idempotent, so that
+// databases migrated with goose can switch to Migrate.
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migration is the Up section of one embedded migration file
+type migration struct {
+	version int64
+	name    string
+	sql     string
+}
+
+// Migrate creates or updates the transition table by applying the embedded
+// migrations that have not been applied yet. Applied versions are recorded
+// in a <table>_migrations table next to the transition table. Migrate holds
+// a PostgreSQL advisory lock while it runs, so several instances can call it
+// at startup; all but the first wait and then find nothing to do.
+func (p *PostgresStorage) Migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "simple-fsm:"+p.tableName()); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	if p.schema != "" {
+		if _, err := tx.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{p.schema}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	versionTable := p.migrationTableName()
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc')
+		)
+	`, versionTable))
+	if err != nil {
+		return fmt.Errorf("failed to create migration table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, tx, versionTable)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if _, err := tx.Exec(ctx, p.rewriteMigration(m.sql)); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", m.name, err)
+		}
+		_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", versionTable), m.version, m.name)
+		if err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", m.name, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+
+	return nil
+}
+
+// migrationTableName returns the quoted, schema-qualified name of the table
+// recording applied migrations
+func (p *PostgresStorage) migrationTableName() string {
+	return p.qualify(p.table + "_migrations")
+}
+
+// rewriteMigration points a migration written for the default tables at the
+// configured schema and tables. Index names follow the table names.
+func (p *PostgresStorage) rewriteMigration(sql string) string {
+	for _, r := range []struct{ from, to string }{
+		{DefaultPostgresTable, p.table},
+		{defaultStateTable, p.stateTable()},
+	} {
+		index := regexp.MustCompile(`\bidx_` + r.from + `(\w*)`)
+		sql = index.ReplaceAllStringFunc(sql, func(name string) string {
+			suffix := index.FindStringSubmatch(name)[1]
+			return pgx.Identifier{"idx_" + r.to + suffix}.Sanitize()
+		})
+		table := regexp.MustCompile(`\b` + r.from + `\b`)
+		sql = table.ReplaceAllLiteralString(sql, p.qualify(r.to))
+	}
+	return sql
+}
+
+func appliedMigrations(ctx context.Context, tx pgx.Tx, versionTable string) (map[int64]bool, error) {
+	rows, err := tx.Query(ctx, "SELECT version FROM "+versionTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migration rows: %w", err)
+	}
+
+	return applied, nil
+}
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, path := range names {
+		name := strings.TrimPrefix(path, "migrations/")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q: %w", name, err)
+		}
+
+		content, err := migrationFS.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		up, err := gooseUp(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", name, err)
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: up})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// gooseUp returns the statements of the Up section of a goose migration
+func gooseUp(content string) (string, error) {
+	var (
+		b    strings.Builder
+		inUp bool
+	)
+	for _, line := range strings.Split(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case "-- +goose Up":
+			inUp = true
+			continue
+		case "-- +goose Down":
+			inUp = false
+			continue
+		case "-- +goose StatementBegin", "-- +goose StatementEnd":
+			continue
+		}
+		if inUp {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+
+	if strings.TrimSpace(b.String()) == "" {
+		return "", errors.New("no +goose Up section")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,111 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	if len(migrations) < 3 {
+		t.Fatalf("loadMigrations() count = %v, want at least 3", len(migrations))
+	}
+	if migrations[0].version != 20251104220000 {
+		t.Errorf("first migration version = %v, want 20251104220000", migrations[0].version)
+	}
+
+	for i, m := range migrations {
+		if i > 0 && m.version <= migrations[i-1].version {
+			t.Errorf("migration %s is out of order", m.name)
+		}
+		if strings.Contains(m.sql, "+goose") {
+			t.Errorf("migration %s contains goose annotations:\n%s", m.name, m.sql)
+		}
+		if strings.Contains(m.sql, "DROP TABLE") {
+			t.Errorf("migration %s contains its Down section:\n%s", m.name, m.sql)
+		}
+	}
+}
+
+func TestPostgresStorage_RewriteMigration(t *testing.T) {
+	sql := `CREATE TABLE IF NOT EXISTS entity_state_transition (id UUID);
+CREATE INDEX IF NOT EXISTS idx_entity_state_transition_entity
+    ON entity_state_transition(entity_type, entity_id);
+INSERT INTO entity_current_state SELECT * FROM entity_state_transition;`
+
+	storage := NewPostgresStorageFromPool(nil, WithSchema("billing"), WithTable("invoice_state"))
+	got := storage.rewriteMigration(sql)
+
+	want := `CREATE TABLE IF NOT EXISTS "billing"."invoice_state" (id UUID);
+CREATE INDEX IF NOT EXISTS "idx_invoice_state_entity"
+    ON "billing"."invoice_state"(entity_type, entity_id);
+INSERT INTO "billing"."invoice_state_current_state" SELECT * FROM "billing"."invoice_state";`
+	if got != want {
+		t.Errorf("rewriteMigration() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPostgresStorage_Migrate(t *testing.T) {
+	ctx := context.Background()
+	connString := getTestPostgresConnString(t)
+
+	schema := fmt.Sprintf("fsm_migrate_%d", time.Now().UnixNano())
+	storage, err := NewPostgresStorage(ctx, connString, WithSchema(schema))
+	if err != nil {
+		t.Fatalf("Failed to create PostgreSQL storage: %v", err)
+	}
+	defer storage.Close()
+	defer storage.pool.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+
+	// Concurrent startups all succeed and apply each migration once
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = storage.Migrate(ctx)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Migrate() error = %v", err)
+		}
+	}
+
+	migrations, _ := loadMigrations()
+	var applied int
+	err = storage.pool.QueryRow(ctx, "SELECT COUNT(*) FROM "+storage.migrationTableName()).Scan(&applied)
+	if err != nil {
+		t.Fatalf("failed to count applied migrations: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("applied migrations = %v, want %v", applied, len(migrations))
+	}
+
+	// Running again is a no-op
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() again error = %v", err)
+	}
+
+	// The migrated table is usable
+	entity := Entity{Type: "document", ID: "doc-migrate"}
+	start := EntityTransition{
+		Entity:     entity,
+		Transition: Transition{To: State{Name: "draft"}, Event: Event{Name: "start"}, CreatedAt: time.Now().UTC()},
+	}
+	if err := storage.CompareAndSaveTransition(ctx, EntityState{Entity: entity}, start); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+	if state, err := storage.GetCurrentState(ctx, entity); err != nil || state.Name != "draft" {
+		t.Errorf("GetCurrentState() = %v, %v, want draft", state.Name, err)
+	}
+}
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Add per-entity version column used for optimistic concurrency control
+ALTER TABLE entity_state_transition
+    ADD COLUMN IF NOT EXISTS version BIGINT;
+
+-- Backfill versions for existing history in chronological order
+UPDATE entity_state_transition t
+SET version = v.version
+FROM (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY entity_type, entity_id
+        ORDER BY created_at, id
+    ) AS version
+    FROM entity_state_transition
+) v
+WHERE t.id = v.id AND t.version IS NULL;
+
+-- Two writers can never record the same version of an entity
+CREATE UNIQUE INDEX IF NOT EXISTS idx_entity_state_transition_version
+    ON entity_state_transition(entity_type, entity_id, version);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_entity_state_transition_version;
+
+ALTER TABLE entity_state_transition
+    DROP COLUMN IF EXISTS version;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Store the event payload and transition metadata as JSON
+ALTER TABLE entity_state_transition
+    ADD COLUMN IF NOT EXISTS event_payload JSONB;
+
+ALTER TABLE entity_state_transition
+    ADD COLUMN IF NOT EXISTS metadata JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_state_transition
+    DROP COLUMN IF EXISTS metadata;
+
+ALTER TABLE entity_state_transition
+    DROP COLUMN IF EXISTS event_payload;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Current state projection, kept in step with entity_state_transition so
+-- that reads do not scan the history
+CREATE TABLE IF NOT EXISTS entity_current_state (
+    entity_type VARCHAR(255) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    state VARCHAR(255) NOT NULL,
+    version BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (entity_type, entity_id)
+);
+
+-- Backfill from existing history
+INSERT INTO entity_current_state (entity_type, entity_id, state, version, updated_at)
+SELECT DISTINCT ON (entity_type, entity_id) entity_type, entity_id, to_state, version, created_at
+FROM entity_state_transition
+ORDER BY entity_type, entity_id, version DESC
+ON CONFLICT (entity_type, entity_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS entity_current_state;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every transition carries its per-entity sequence number
+ALTER TABLE entity_state_transition
+    ALTER COLUMN version SET NOT NULL;
+
+-- Sequence numbers are unique per entity. The constraint takes over the
+-- unique index created with the version column.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conrelid = 'entity_state_transition'::regclass AND contype = 'u'
+    ) THEN
+        ALTER TABLE entity_state_transition
+            ADD CONSTRAINT idx_entity_state_transition_version
+            UNIQUE USING INDEX idx_entity_state_transition_version;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Dropping the constraint drops its index, so recreate the index
+ALTER TABLE entity_state_transition
+    DROP CONSTRAINT IF EXISTS idx_entity_state_transition_version;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_entity_state_transition_version
+    ON entity_state_transition(entity_type, entity_id, version);
+
+ALTER TABLE entity_state_transition
+    ALTER COLUMN version DROP NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Indexes for listing the entities of a type in a state, by ID or by the
+-- time they entered it
+CREATE INDEX IF NOT EXISTS idx_entity_current_state_state
+    ON entity_current_state(entity_type, state, entity_id);
+
+CREATE INDEX IF NOT EXISTS idx_entity_current_state_state_updated_at
+    ON entity_current_state(entity_type, state, updated_at, entity_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_entity_current_state_state_updated_at;
+DROP INDEX IF EXISTS idx_entity_current_state_state;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Extended state data of each entity, saved together with its transitions
+ALTER TABLE entity_current_state
+    ADD COLUMN IF NOT EXISTS data JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_current_state
+    DROP COLUMN IF EXISTS data;
+-- +goose StatementEnd
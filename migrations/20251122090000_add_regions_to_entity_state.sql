@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- States of the regions of a parallel state, as a JSON array of state names,
+-- saved with each transition into a parallel state and kept in the current
+-- state projection
+ALTER TABLE entity_state_transition
+    ADD COLUMN IF NOT EXISTS regions JSONB;
+
+ALTER TABLE entity_current_state
+    ADD COLUMN IF NOT EXISTS regions JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_current_state
+    DROP COLUMN IF EXISTS regions;
+
+ALTER TABLE entity_state_transition
+    DROP COLUMN IF EXISTS regions;
+-- +goose StatementEnd
@@ -0,0 +1,405 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Parallel declares a parallel state: an entity in it is in one state of each
+// of its regions at once. An event is routed to every region with a
+// transition for it from the region's current state; if no region accepts
+// the event, the transitions of the parallel state itself, which leave it,
+// are tried. Entering the parallel state enters the initial state of every
+// region.
+type Parallel struct {
+	State   State
+	Regions []Region
+	// Join is the state entered, by a transition with the event "join", as
+	// soon as every region is in one of its final states. Without a Join
+	// the entity stays in the parallel state.
+	//
+	// The join is saved together with the transition that completes the
+	// last region, as one step. The final states that transition enters are
+	// left again at once, so their enter and exit hooks do not run. The
+	// before-transition and exit hooks of both transitions run before either
+	// is saved, and any of them failing aborts the event as well as the join.
+	Join State
+}
+
+// Region is an orthogonal region of a parallel state
+type Region struct {
+	// State is the composite state holding the states of the region. It is
+	// nested in the parallel state.
+	State State
+	// Initial is the state the region starts in
+	Initial State
+	// Final are the states in which the region is done
+	Final []State
+}
+
+// WithParallel declares a parallel state, nesting its regions in it. The
+// storage must implement ParallelStorage for entities to enter it.
+func WithParallel(p Parallel) Option {
+	return func(f *FSM) {
+		f.parallel = append(f.parallel, p)
+		f.substates = append(f.substates, p.substates()...)
+	}
+}
+
+// substates returns the regions of p as substates of p.State
+func (p Parallel) substates() []State {
+	states := make([]State, len(p.Regions))
+	for i, r := range p.Regions {
+		states[i] = State{Name: r.State.Name, Parent: p.State.Name}
+	}
+	return states
+}
+
+// ParallelStorage is implemented by storage that can keep entities in
+// parallel states. SaveTransition and CompareAndSaveTransition save
+// et.Regions together with the transition.
+type ParallelStorage interface {
+	// GetEntityRegions returns the current state and version of an entity
+	// together with the Regions of its latest transition, which are nil
+	// unless it is in a parallel state
+	GetEntityRegions(ctx context.Context, entity Entity) (EntityState, []State, error)
+	// CompareAndSaveTransitions saves ets in order if the entity is still in
+	// expected.State at expected.Version, as CompareAndSaveTransition does
+	// for one transition. Either all of them are saved or none.
+	CompareAndSaveTransitions(ctx context.Context, expected EntityState, ets []EntityTransition) error
+}
+
+var errUnsupportedParallel = fmt.Errorf("%w: storage does not implement ParallelStorage", errors.ErrUnsupported)
+
+// joinEvent is the event recorded for a join transition
+var joinEvent = Event{Name: "join"}
+
+// validateParallel checks the parallel states of f against its state tree
+// and transitions
+func (f *FSM) validateParallel() error {
+	for i, p := range f.parallel {
+		if err := validateState(p.State, f.states); err != nil {
+			return fmt.Errorf("invalid parallel state: %w", err)
+		}
+		if len(p.Regions) == 0 {
+			return fmt.Errorf("%w: parallel state %q has no regions", ErrInvalidState, p.State.Name)
+		}
+		for _, other := range f.parallel[:i] {
+			if f.tree.isWithin(p.State.Name, other.State.Name) || f.tree.isWithin(other.State.Name, p.State.Name) {
+				return fmt.Errorf("%w: parallel state %q is nested in parallel state %q", ErrInvalidState, p.State.Name, other.State.Name)
+			}
+		}
+
+		regions := make(map[string]bool, len(p.Regions))
+		for _, r := range p.Regions {
+			regions[r.State.Name] = true
+			if err := f.validateRegion(p, r); err != nil {
+				return err
+			}
+		}
+		for _, s := range f.states {
+			if f.tree.parent[s.Name] == p.State.Name && !regions[s.Name] {
+				return fmt.Errorf("%w: state %q is nested in parallel state %q but is not one of its regions", ErrInvalidState, s.Name, p.State.Name)
+			}
+		}
+
+		if p.Join.Name != "" {
+			if err := validateState(p.Join, f.states); err != nil {
+				return fmt.Errorf("invalid join state of %q: %w", p.State.Name, err)
+			}
+			if f.tree.isWithin(p.Join.Name, p.State.Name) {
+				return fmt.Errorf("%w: join state %q is nested in parallel state %q", ErrInvalidState, p.Join.Name, p.State.Name)
+			}
+		}
+	}
+
+	for _, t := range f.transitions {
+		from, to := f.regionOf(t.From.Name), f.regionOf(t.To.Name)
+		if from != to {
+			return fmt.Errorf("%w: transition from %q to %q with event %q crosses the boundary of a region",
+				ErrInvalidTransition, t.From.Name, t.To.Name, t.Event.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateRegion checks that r is a composite state whose initial and final
+// states are nested in it
+func (f *FSM) validateRegion(p Parallel, r Region) error {
+	if err := validateState(r.State, f.states); err != nil {
+		return fmt.Errorf("invalid region of %q: %w", p.State.Name, err)
+	}
+	if !f.tree.isComposite(r.State.Name) {
+		return fmt.Errorf("%w: region %q has no states", ErrInvalidState, r.State.Name)
+	}
+
+	if err := validateState(r.Initial, f.states); err != nil {
+		return fmt.Errorf("invalid initial state of region %q: %w", r.State.Name, err)
+	}
+	if !f.tree.isWithin(r.Initial.Name, r.State.Name) || f.tree.isComposite(r.Initial.Name) {
+		return fmt.Errorf("%w: initial state %q is not a leaf state of region %q", ErrInvalidState, r.Initial.Name, r.State.Name)
+	}
+
+	for _, s := range r.Final {
+		if err := validateState(s, f.states); err != nil {
+			return fmt.Errorf("invalid final state of region %q: %w", r.State.Name, err)
+		}
+		if !f.tree.isWithin(s.Name, r.State.Name) {
+			return fmt.Errorf("%w: final state %q is not nested in region %q", ErrInvalidState, s.Name, r.State.Name)
+		}
+	}
+	return nil
+}
+
+// parallelState returns the parallel state named name, or nil
+func (f *FSM) parallelState(name string) *Parallel {
+	for i := range f.parallel {
+		if f.parallel[i].State.Name == name {
+			return &f.parallel[i]
+		}
+	}
+	return nil
+}
+
+// region returns the region named name, or nil
+func (f *FSM) region(name string) *Region {
+	if p := f.parallelState(f.tree.parent[name]); p != nil {
+		for i := range p.Regions {
+			if p.Regions[i].State.Name == name {
+				return &p.Regions[i]
+			}
+		}
+	}
+	return nil
+}
+
+// joins returns the join transitions of the parallel states, which are not
+// declared as transitions
+func (f *FSM) joins() []Transition {
+	var joins []Transition
+	for _, p := range f.parallel {
+		if p.Join.Name != "" {
+			joins = append(joins, Transition{From: p.State, To: p.Join, Event: joinEvent})
+		}
+	}
+	return joins
+}
+
+// regionOf returns the region name is nested in, or "" if it is in none
+func (f *FSM) regionOf(name string) string {
+	lineage := f.tree.lineage(name)
+	for i, n := range lineage[:len(lineage)-1] {
+		if f.parallelState(lineage[i+1]) != nil {
+			return n
+		}
+	}
+	return ""
+}
+
+// initialRegions returns the initial state of each region of p
+func (p *Parallel) initialRegions() []State {
+	regions := make([]State, len(p.Regions))
+	for i, r := range p.Regions {
+		regions[i] = State{Name: r.Initial.Name}
+	}
+	return regions
+}
+
+// done reports whether every region is in one of its final states
+func (p *Parallel) done(regions []State) bool {
+	for i, r := range p.Regions {
+		if validateState(regions[i], r.Final) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// regionLineage returns name followed by its ancestors up to and including
+// region, innermost first
+func (f *FSM) regionLineage(name, region string) []string {
+	lineage := f.tree.lineage(name)
+	if i := slices.Index(lineage, region); i >= 0 {
+		return lineage[:i+1]
+	}
+	return lineage
+}
+
+// getRegions returns the region states of an entity in a parallel state, read
+// at the version of current, or nil for an entity in any other state
+func (f *FSM) getRegions(ctx context.Context, current EntityState) ([]State, error) {
+	p := f.parallelState(current.State.Name)
+	if p == nil {
+		return nil, nil
+	}
+	ps, ok := f.storage.(ParallelStorage)
+	if !ok {
+		return nil, errUnsupportedParallel
+	}
+
+	es, regions, err := ps.GetEntityRegions(ctx, current.Entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get region states: %w", err)
+	}
+	if es.Version != current.Version || es.State.Name != current.State.Name {
+		return nil, ErrConcurrentModification
+	}
+	if len(regions) != len(p.Regions) {
+		return nil, fmt.Errorf("%w: %s/%s has %d region states in %q, want %d",
+			ErrInvalidState, current.Entity.Type, current.Entity.ID, len(regions), current.State.Name, len(p.Regions))
+	}
+	return regions, nil
+}
+
+// GetActiveStates returns the states an entity is in: the state of each
+// region for an entity in a parallel state, or just its current state
+func (f *FSM) GetActiveStates(ctx context.Context, entity Entity) ([]State, error) {
+	if ps, ok := f.storage.(ParallelStorage); ok {
+		es, regions, err := ps.GetEntityRegions(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+		if regions != nil {
+			return regions, nil
+		}
+		return []State{es.State}, nil
+	}
+
+	state, err := f.storage.GetCurrentState(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+	return []State{state}, nil
+}
+
+// move is one transition planned by Trigger, together with the states it
+// leaves and enters, for running hooks
+type move struct {
+	to      State
+	event   Event
+	regions []State
+	exited  []string
+	entered []string
+}
+
+// newMove plans a transition from a state, in the given regions if it is a
+// parallel state, to another, entering the initial states of its regions if
+// it is a parallel state
+func (f *FSM) newMove(from State, regions []State, to State, event Event) move {
+	m := move{to: to, event: event}
+	if p := f.parallelState(to.Name); p != nil {
+		m.regions = p.initialRegions()
+	}
+
+	if from.Name != "" {
+		if f.parallelState(from.Name) != nil {
+			for _, r := range regions {
+				lineage := f.tree.lineage(r.Name)
+				m.exited = append(m.exited, lineage[:slices.Index(lineage, from.Name)]...)
+			}
+		}
+		m.exited = append(m.exited, f.tree.exited(from.Name, to.Name)...)
+	}
+
+	m.entered = f.tree.entered(from.Name, to.Name)
+	for _, r := range m.regions {
+		m.entered = append(m.entered, f.tree.entered(to.Name, r.Name)...)
+	}
+	return m
+}
+
+// plan finds the transitions event causes for an entity in current, whose
+// regions are given if it is in a parallel state. In a parallel state the
+// event moves every region that accepts it, followed by the join transition
+// if that leaves every region final; otherwise, or outside a parallel state,
+// it is a single transition.
+func (f *FSM) plan(ctx context.Context, entity Entity, current State, data []byte, regions []State, event Event) ([]move, error) {
+	p := f.parallelState(current.Name)
+	if p == nil {
+		to, err := f.resolveNextState(ctx, entity, current, data, event)
+		if err != nil {
+			return nil, err
+		}
+		return []move{f.newMove(current, regions, to, event)}, nil
+	}
+
+	in := GuardInput{Entity: entity, Data: data, Event: event}
+	routed := move{to: current, event: event, regions: slices.Clone(regions)}
+	var moved bool
+	var veto error
+	for i, r := range p.Regions {
+		in.State = regions[i]
+		to, ok, err := f.resolve(ctx, in, f.regionLineage(regions[i].Name, r.State.Name))
+		if !ok {
+			veto = firstErr(veto, err)
+			continue
+		}
+		moved = true
+		routed.regions[i] = to
+		routed.exited = append(routed.exited, f.tree.exited(regions[i].Name, to.Name)...)
+		routed.entered = append(routed.entered, f.tree.entered(regions[i].Name, to.Name)...)
+	}
+
+	if moved {
+		if p.Join.Name == "" || !p.done(routed.regions) {
+			return []move{routed}, nil
+		}
+
+		// The join leaves the states the event just entered, so the entity
+		// never rests in them and their hooks do not run
+		join := f.newMove(current, routed.regions, p.Join, joinEvent)
+		transient := routed.entered
+		routed.entered = slices.DeleteFunc(slices.Clone(routed.entered), func(s string) bool {
+			return slices.Contains(join.exited, s)
+		})
+		join.exited = slices.DeleteFunc(join.exited, func(s string) bool {
+			return slices.Contains(transient, s)
+		})
+		return []move{routed, join}, nil
+	}
+
+	in.State = current
+	to, ok, err := f.resolve(ctx, in, f.tree.lineage(current.Name))
+	if ok {
+		return []move{f.newMove(current, regions, to, event)}, nil
+	}
+	if veto = firstErr(veto, err); veto != nil {
+		return nil, veto
+	}
+	return nil, fmt.Errorf("%w: no transition from %q with event %q",
+		ErrInvalidTransition, current.Name, event.Name)
+}
+
+// firstErr returns the first non-nil error
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveTransitions saves ets for an entity in current,
+// requiring ParallelStorage if any of them involves a parallel state
+func (f *FSM) saveTransitions(ctx context.Context, current EntityState, ets []EntityTransition) error {
+	parallel := f.parallelState(current.State.Name) != nil
+	for _, et := range ets {
+		parallel = parallel || et.Regions != nil
+	}
+	if !parallel {
+		return f.storage.CompareAndSaveTransition(ctx, current, ets[0])
+	}
+
+	ps, ok := f.storage.(ParallelStorage)
+	if !ok {
+		return errUnsupportedParallel
+	}
+	if len(ets) == 1 {
+		return f.storage.CompareAndSaveTransition(ctx, current, ets[0])
+	}
+	return ps.CompareAndSaveTransitions(ctx, current, ets)
+}
@@ -0,0 +1,630 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var loanReviewStates = []State{
+	{Name: "draft"},
+	{Name: "review"},
+	{Name: "documents"},
+	{Name: "collecting", Parent: "documents"},
+	{Name: "verified", Parent: "documents"},
+	{Name: "credit"},
+	{Name: "checking", Parent: "credit"},
+	{Name: "passed", Parent: "credit"},
+	{Name: "failed", Parent: "credit"},
+	{Name: "approved"},
+	{Name: "withdrawn"},
+}
+
+var loanReviewParallel = Parallel{
+	State: State{Name: "review"},
+	Regions: []Region{
+		{State: State{Name: "documents"}, Initial: State{Name: "collecting"}, Final: []State{{Name: "verified"}}},
+		{State: State{Name: "credit"}, Initial: State{Name: "checking"}, Final: []State{{Name: "passed"}}},
+	},
+	Join: State{Name: "approved"},
+}
+
+// newLoanReviewFSM creates a loan workflow whose review checks documents and
+// credit in parallel, joining to approved once both are done
+func newLoanReviewFSM(t *testing.T, storage Storage, guards ...Guard) *FSM {
+	t.Helper()
+
+	events := []Event{{Name: "submit"}, {Name: "verify"}, {Name: "pass"}, {Name: "fail"}, {Name: "retry"}, {Name: "waive"}, {Name: "withdraw"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "review"}, Event: Event{Name: "submit"}},
+		{From: State{Name: "collecting"}, To: State{Name: "verified"}, Event: Event{Name: "verify"}, Guards: guards},
+		{From: State{Name: "checking"}, To: State{Name: "passed"}, Event: Event{Name: "pass"}},
+		{From: State{Name: "checking"}, To: State{Name: "failed"}, Event: Event{Name: "fail"}},
+		{From: State{Name: "failed"}, To: State{Name: "checking"}, Event: Event{Name: "retry"}},
+		{From: State{Name: "collecting"}, To: State{Name: "verified"}, Event: Event{Name: "waive"}},
+		{From: State{Name: "checking"}, To: State{Name: "passed"}, Event: Event{Name: "waive"}},
+		{From: State{Name: "review"}, To: State{Name: "withdrawn"}, Event: Event{Name: "withdraw"}},
+	}
+
+	machine, err := New(loanReviewStates, events, transitions, storage,
+		WithInitialStates(State{Name: "draft"}),
+		WithTerminalStates(State{Name: "approved"}, State{Name: "withdrawn"}),
+		WithParallel(loanReviewParallel),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return machine
+}
+
+func wantActiveStates(t *testing.T, machine *FSM, entity Entity, want ...string) {
+	t.Helper()
+	active, err := machine.GetActiveStates(context.Background(), entity)
+	if err != nil {
+		t.Fatalf("GetActiveStates() error = %v", err)
+	}
+	var got []string
+	for _, s := range active {
+		got = append(got, s.Name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetActiveStates() = %v, want %v", got, want)
+	}
+}
+
+func TestFSM_ParallelStates(t *testing.T) {
+	ctx := context.Background()
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	wantActiveStates(t, machine, loan, "draft")
+
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+	wantActiveStates(t, machine, loan, "collecting", "checking")
+
+	events, err := machine.GetAvailableEvents(ctx, loan)
+	if err != nil {
+		t.Fatalf("GetAvailableEvents() error = %v", err)
+	}
+	want := []Event{{Name: "verify"}, {Name: "waive"}, {Name: "pass"}, {Name: "fail"}, {Name: "withdraw"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("GetAvailableEvents() = %v, want %v", events, want)
+	}
+
+	for _, s := range []string{"review", "documents", "collecting", "checking"} {
+		if in, err := machine.IsInState(ctx, loan, State{Name: s}); err != nil || !in {
+			t.Errorf("IsInState(%s) = %v, %v, want true", s, in, err)
+		}
+	}
+	if in, err := machine.IsInState(ctx, loan, State{Name: "verified"}); err != nil || in {
+		t.Errorf("IsInState(verified) = %v, %v, want false", in, err)
+	}
+
+	// Each event moves only the region that accepts it
+	for _, event := range []string{"verify", "fail", "retry"} {
+		if err := machine.Trigger(ctx, loan, Event{Name: event}, "bob"); err != nil {
+			t.Fatalf("Trigger(%s) error = %v", event, err)
+		}
+	}
+	wantActiveStates(t, machine, loan, "verified", "checking")
+	if machine.CanTrigger(ctx, loan, Event{Name: "verify"}) {
+		t.Error("CanTrigger(verify) = true after the documents were verified")
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "verify"}, "bob"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Trigger(verify) error = %v, want ErrInvalidTransition", err)
+	}
+
+	// The last region to finish joins
+	if err := machine.Trigger(ctx, loan, Event{Name: "pass"}, "carol"); err != nil {
+		t.Fatalf("Trigger(pass) error = %v", err)
+	}
+	state, err := machine.GetState(ctx, loan)
+	if err != nil || state.Name != "approved" {
+		t.Errorf("GetState() = %v, %v, want approved", state, err)
+	}
+	wantActiveStates(t, machine, loan, "approved")
+
+	history, err := machine.GetTransitions(ctx, loan)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	var got []string
+	for _, et := range history {
+		got = append(got, et.Transition.From.Name+" -"+et.Transition.Event.Name+"-> "+et.Transition.To.Name)
+	}
+	wantHistory := []string{
+		" -start-> draft",
+		"draft -submit-> review",
+		"review -verify-> review",
+		"review -fail-> review",
+		"review -retry-> review",
+		"review -pass-> review",
+		"review -join-> approved",
+	}
+	if !reflect.DeepEqual(got, wantHistory) {
+		t.Errorf("history = %q, want %q", got, wantHistory)
+	}
+	if regions := history[5].Regions; !reflect.DeepEqual(regions, []State{{Name: "verified"}, {Name: "passed"}}) {
+		t.Errorf("pass saved regions %v, want [verified passed]", regions)
+	}
+	if last := history[len(history)-1]; last.Sequence != 7 || last.Regions != nil {
+		t.Errorf("join saved at sequence %d with regions %v, want 7 and none", last.Sequence, last.Regions)
+	}
+
+	counts, err := machine.CountTransitions(ctx, loan)
+	if err != nil {
+		t.Fatalf("CountTransitions() error = %v", err)
+	}
+	if n := counts[TransitionKey{From: "checking", Event: "fail", To: "failed"}]; n != 1 {
+		t.Errorf("CountTransitions() counted checking -fail-> failed %d times, want 1", n)
+	}
+}
+
+func TestFSM_ParallelStateAllRegions(t *testing.T) {
+	ctx := context.Background()
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+
+	// waive moves both regions in one transition, which then joins
+	if err := machine.Trigger(ctx, loan, Event{Name: "waive"}, "bob"); err != nil {
+		t.Fatalf("Trigger(waive) error = %v", err)
+	}
+	history, err := machine.GetTransitions(ctx, loan)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("GetTransitions() returned %d transitions, want 4", len(history))
+	}
+	if waive := history[2]; waive.Transition.Event.Name != "waive" || !reflect.DeepEqual(waive.Regions, []State{{Name: "verified"}, {Name: "passed"}}) {
+		t.Errorf("history[2] = %s with regions %v, want waive with [verified passed]", waive.Transition.Event.Name, waive.Regions)
+	}
+	if join := history[3]; join.Transition.Event.Name != "join" || join.Transition.To.Name != "approved" {
+		t.Errorf("history[3] = %s to %s, want join to approved", join.Transition.Event.Name, join.Transition.To.Name)
+	}
+}
+
+func TestFSM_ParallelStateHooks(t *testing.T) {
+	ctx := context.Background()
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+
+	var calls []string
+	for _, s := range loanReviewStates {
+		mustRegister(t, machine.OnEnter(s, func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, "enter "+s.Name)
+			return nil
+		}))
+		mustRegister(t, machine.OnExit(s, func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, "exit "+s.Name)
+			return nil
+		}))
+	}
+	mustRegister(t, machine.AfterTransition(Event{Name: "pass"}, func(ctx context.Context, et EntityTransition) error {
+		calls = append(calls, "after pass")
+		return nil
+	}))
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	calls = nil
+	for _, event := range []string{"submit", "verify", "pass"} {
+		if err := machine.Trigger(ctx, loan, Event{Name: event}, "alice"); err != nil {
+			t.Fatalf("Trigger(%s) error = %v", event, err)
+		}
+	}
+
+	want := []string{
+		"exit draft", "enter review", "enter documents", "enter collecting", "enter credit", "enter checking",
+		"exit collecting", "enter verified",
+		"exit checking", "exit verified", "exit documents", "exit credit", "exit review",
+		"after pass", "enter approved",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks ran in order\n%q\nwant\n%q", calls, want)
+	}
+}
+
+func TestFSM_ParallelJoinHooks(t *testing.T) {
+	ctx := context.Background()
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+
+	var calls []string
+	var leaving error
+	for _, s := range loanReviewStates {
+		mustRegister(t, machine.OnEnter(s, func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, "enter "+s.Name)
+			return nil
+		}))
+		mustRegister(t, machine.OnExit(s, func(ctx context.Context, et EntityTransition) error {
+			calls = append(calls, "exit "+s.Name)
+			if s.Name == "review" {
+				return leaving
+			}
+			return nil
+		}))
+	}
+	mustRegister(t, machine.AfterTransition(Event{Name: "waive"}, func(ctx context.Context, et EntityTransition) error {
+		calls = append(calls, "after waive")
+		return nil
+	}))
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+
+	// A failing exit hook of the join rejects the event that caused it
+	leaving = errors.New("review still open")
+	err := machine.Trigger(ctx, loan, Event{Name: "waive"}, "alice")
+	if !errors.Is(err, leaving) {
+		t.Fatalf("Trigger(waive) error = %v, want the exit hook's error", err)
+	}
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Committed() {
+		t.Errorf("Trigger(waive) error = %v, want an uncommitted *HookError", err)
+	}
+	wantActiveStates(t, machine, loan, "collecting", "checking")
+
+	// The event enters the final states and the join leaves them in the
+	// same step, so only the states left for good and the join state run
+	// hooks, every exit before every enter
+	leaving = nil
+	calls = nil
+	if err := machine.Trigger(ctx, loan, Event{Name: "waive"}, "alice"); err != nil {
+		t.Fatalf("Trigger(waive) error = %v", err)
+	}
+	want := []string{
+		"exit collecting", "exit checking",
+		"exit documents", "exit credit", "exit review",
+		"after waive", "enter approved",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks ran in order\n%q\nwant\n%q", calls, want)
+	}
+	wantActiveStates(t, machine, loan, "approved")
+}
+
+func TestFSM_ParallelGetNextState(t *testing.T) {
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+
+	// The regions decide what their events do
+	_, err := machine.GetNextState(State{Name: "review"}, Event{Name: "pass"})
+	if !errors.Is(err, ErrParallelState) {
+		t.Errorf("GetNextState(review, pass) error = %v, want ErrParallelState", err)
+	}
+
+	tests := []struct {
+		from, event, want string
+	}{
+		{"review", "withdraw", "withdrawn"},
+		{"checking", "pass", "passed"},
+		{"collecting", "waive", "verified"},
+	}
+	for _, tt := range tests {
+		got, err := machine.GetNextState(State{Name: tt.from}, Event{Name: tt.event})
+		if err != nil || got.Name != tt.want {
+			t.Errorf("GetNextState(%s, %s) = %v, %v, want %s", tt.from, tt.event, got.Name, err, tt.want)
+		}
+	}
+
+	_, err = machine.GetNextState(State{Name: "review"}, Event{Name: "submit"})
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("GetNextState(review, submit) error = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestFSM_ParallelStateLeave(t *testing.T) {
+	ctx := context.Background()
+	veto := errors.New("documents incomplete")
+	machine := newLoanReviewFSM(t, NewMemoryStorage(), func(ctx context.Context, in GuardInput) error {
+		if in.State.Name != "collecting" {
+			t.Errorf("guard called in state %q, want collecting", in.State.Name)
+		}
+		return veto
+	})
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "verify"}, "alice"); !errors.Is(err, veto) {
+		t.Errorf("Trigger(verify) error = %v, want the guard's veto", err)
+	}
+
+	// An event no region accepts is looked up on the parallel state
+	if err := machine.Trigger(ctx, loan, Event{Name: "withdraw"}, "alice"); err != nil {
+		t.Fatalf("Trigger(withdraw) error = %v", err)
+	}
+	wantActiveStates(t, machine, loan, "withdrawn")
+
+	// Reset leaves the regions, and entering the parallel state starts them over
+	if err := machine.Reset(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); err != nil {
+		t.Fatalf("Trigger(submit) error = %v", err)
+	}
+	if err := machine.Reset(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	wantActiveStates(t, machine, loan, "draft")
+}
+
+func TestFSM_ParallelStateUnsupportedStorage(t *testing.T) {
+	ctx := context.Background()
+	machine := newLoanReviewFSM(t, struct{ Storage }{NewMemoryStorage()})
+
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, State{Name: "draft"}, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, loan, Event{Name: "submit"}, "alice"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Trigger(submit) error = %v, want errors.ErrUnsupported", err)
+	}
+	wantActiveStates(t, machine, loan, "draft")
+}
+
+func TestNew_ParallelStateErrors(t *testing.T) {
+	events := []Event{{Name: "go"}}
+	states := []State{{Name: "a"}, {Name: "p"}, {Name: "r"}, {Name: "r1", Parent: "r"}, {Name: "r2", Parent: "r"}, {Name: "b"}}
+	transitions := []Transition{{From: State{Name: "a"}, To: State{Name: "p"}, Event: Event{Name: "go"}}}
+	region := Region{State: State{Name: "r"}, Initial: State{Name: "r1"}, Final: []State{{Name: "r2"}}}
+
+	tests := []struct {
+		name        string
+		states      []State
+		transitions []Transition
+		parallel    Parallel
+		want        error
+		wantMsg     string
+	}{
+		{
+			name:     "no regions",
+			parallel: Parallel{State: State{Name: "p"}},
+			want:     ErrInvalidState,
+			wantMsg:  `parallel state "p" has no regions`,
+		},
+		{
+			name:     "region without states",
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{{State: State{Name: "b"}, Initial: State{Name: "r1"}}}},
+			want:     ErrInvalidState,
+			wantMsg:  `region "b" has no states`,
+		},
+		{
+			name:     "initial state outside region",
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{{State: State{Name: "r"}, Initial: State{Name: "a"}}}},
+			want:     ErrInvalidState,
+			wantMsg:  `initial state "a" is not a leaf state of region "r"`,
+		},
+		{
+			name:     "final state outside region",
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{{State: State{Name: "r"}, Initial: State{Name: "r1"}, Final: []State{{Name: "b"}}}}},
+			want:     ErrInvalidState,
+			wantMsg:  `final state "b" is not nested in region "r"`,
+		},
+		{
+			name:     "join inside parallel state",
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{region}, Join: State{Name: "r2"}},
+			want:     ErrInvalidState,
+			wantMsg:  `join state "r2" is nested in parallel state "p"`,
+		},
+		{
+			name:     "undeclared join",
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{region}, Join: State{Name: "done"}},
+			want:     ErrInvalidState,
+			wantMsg:  "invalid join state",
+		},
+		{
+			name:     "substate that is not a region",
+			states:   append(states[:len(states):len(states)], State{Name: "c", Parent: "p"}),
+			parallel: Parallel{State: State{Name: "p"}, Regions: []Region{region}},
+			want:     ErrInvalidState,
+			wantMsg:  `state "c" is nested in parallel state "p" but is not one of its regions`,
+		},
+		{
+			name:        "transition into region",
+			transitions: []Transition{{From: State{Name: "a"}, To: State{Name: "r2"}, Event: Event{Name: "go"}}},
+			parallel:    Parallel{State: State{Name: "p"}, Regions: []Region{region}},
+			want:        ErrInvalidTransition,
+			wantMsg:     "crosses the boundary of a region",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.states == nil {
+				tt.states = states
+			}
+			if tt.transitions == nil {
+				tt.transitions = transitions
+			}
+			_, err := New(tt.states, events, tt.transitions, NewMemoryStorage(), WithParallel(tt.parallel))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("New() error = %v, want %v", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("New() error = %v, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestFSM_StartInRegion(t *testing.T) {
+	machine := newLoanReviewFSM(t, NewMemoryStorage())
+	machine.initialStates = nil
+	ctx := context.Background()
+
+	err := machine.Start(ctx, Entity{Type: "loan", ID: "loan-1"}, State{Name: "collecting"}, "alice")
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Start(collecting) error = %v, want ErrInvalidState", err)
+	}
+
+	loan := Entity{Type: "loan", ID: "loan-2"}
+	if err := machine.Start(ctx, loan, State{Name: "review"}, "alice"); err != nil {
+		t.Fatalf("Start(review) error = %v", err)
+	}
+	wantActiveStates(t, machine, loan, "collecting", "checking")
+}
+
+func TestLoadDefinition_ParallelStates(t *testing.T) {
+	input := `name: loan
+states:
+  - draft
+  - review
+  - documents
+  - {name: collecting, parent: documents}
+  - {name: verified, parent: documents}
+  - credit
+  - {name: checking, parent: credit}
+  - {name: passed, parent: credit}
+  - approved
+events: [submit, verify, pass]
+initial: draft
+terminal: [approved]
+parallel:
+  - state: review
+    join: approved
+    regions:
+      - {state: documents, initial: collecting, final: [verified]}
+      - {state: credit, initial: checking, final: [passed]}
+transitions:
+  - {from: draft, event: submit, to: review}
+  - {from: collecting, event: verify, to: verified}
+  - {from: checking, event: pass, to: passed}
+`
+	def, err := LoadDefinition(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+	want := []Parallel{{
+		State: State{Name: "review"},
+		Regions: []Region{
+			{State: State{Name: "documents"}, Initial: State{Name: "collecting"}, Final: []State{{Name: "verified"}}},
+			{State: State{Name: "credit"}, Initial: State{Name: "checking"}, Final: []State{{Name: "passed"}}},
+		},
+		Join: State{Name: "approved"},
+	}}
+	if !reflect.DeepEqual(def.Parallel, want) {
+		t.Errorf("Parallel = %v, want %v", def.Parallel, want)
+	}
+	if findings := def.Lint(); len(findings) != 0 {
+		t.Errorf("Lint() = %v, want no findings", findings)
+	}
+
+	machine, err := def.New(NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if findings := machine.Lint(); len(findings) != 0 {
+		t.Errorf("FSM.Lint() = %v, want no findings", findings)
+	}
+
+	var b strings.Builder
+	if err := machine.WriteMermaid(&b); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+	wantBlock := `    state review {
+        state documents {
+            [*] --> collecting
+            collecting
+            verified
+        }
+        --
+        state credit {
+            [*] --> checking
+            checking
+            passed
+        }
+    }
+`
+	if !strings.Contains(b.String(), wantBlock) || !strings.Contains(b.String(), "    review --> approved : join\n") {
+		t.Errorf("WriteMermaid() has no parallel state block and join:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := machine.WriteDOT(&b); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	if !strings.Contains(b.String(), "\t\"review\" -> \"approved\" [label=\"join\"];\n") {
+		t.Errorf("WriteDOT() has no join edge:\n%s", b.String())
+	}
+}
+
+func TestLoadDefinition_ParallelStateErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "undeclared region",
+			input: `states: [a, p, {name: r1, parent: r}, r]
+events: [go]
+parallel:
+  - state: p
+    regions: [{state: q, initial: r1}]
+transitions:
+  - {from: a, event: go, to: p}
+`,
+			want: `parallel[0].regions[0].state: invalid state: state "q" is not declared`,
+		},
+		{
+			name: "missing initial state",
+			input: `states: [a, p, {name: r1, parent: r}, r]
+events: [go]
+parallel:
+  - state: p
+    regions: [{state: r}]
+transitions:
+  - {from: a, event: go, to: p}
+`,
+			want: "parallel[0].regions[0].initial: is required",
+		},
+		{
+			name: "transition into region",
+			input: `states: [a, p, {name: r1, parent: r}, r]
+events: [go]
+parallel:
+  - state: p
+    regions: [{state: r, initial: r1}]
+transitions:
+  - {from: a, event: go, to: r}
+`,
+			want: `transitions[0].to: invalid state: state "r" is a composite state`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadDefinition(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("LoadDefinition() error = nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadDefinition() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package fsm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not produced by the same
+// kind of query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DefaultQueryLimit is the page size used when a query does not set Limit
+const DefaultQueryLimit = 100
+
+// StateOrder sets the order in which QueryByState returns entities
+type StateOrder int
+
+const (
+	// OrderByID orders entities by ID
+	OrderByID StateOrder = iota
+	// OrderByEnteredAt orders entities by the time they entered the state,
+	// oldest first
+	OrderByEnteredAt
+	// OrderByEnteredAtDesc orders entities by the time they entered the
+	// state, newest first
+	OrderByEnteredAtDesc
+)
+
+// StateQuery selects the entities of one type that are currently in a state
+type StateQuery struct {
+	Type  string
+	State State
+	Order StateOrder
+	// Limit is the maximum number of entities to return, DefaultQueryLimit
+	// if zero
+	Limit int
+	// Cursor continues a previous query from its NextCursor. The query must
+	// otherwise be unchanged.
+	Cursor string
+}
+
+// EntityInState is an entity returned by QueryByState
+type EntityInState struct {
+	EntityState
+	// EnteredAt is the CreatedAt of the transition into the current state
+	EnteredAt time.Time
+}
+
+// StatePage is one page of QueryByState results
+type StatePage struct {
+	Entities []EntityInState
+	// NextCursor fetches the next page, or is empty if this is the last one
+	NextCursor string
+}
+
+// StateQuerier is implemented by storage that can list entities by their
+// current state
+type StateQuerier interface {
+	QueryByState(ctx context.Context, q StateQuery) (StatePage, error)
+}
+
+// QueryByState lists the entities of q.Type currently in q.State. The storage
+// must implement StateQuerier.
+func (f *FSM) QueryByState(ctx context.Context, q StateQuery) (StatePage, error) {
+	if err := validateState(q.State, f.states); err != nil {
+		return StatePage{}, err
+	}
+
+	querier, ok := f.storage.(StateQuerier)
+	if !ok {
+		return StatePage{}, fmt.Errorf("%w: storage does not implement StateQuerier", errors.ErrUnsupported)
+	}
+	return querier.QueryByState(ctx, q)
+}
+
+// StateAtQuerier is implemented by storage that can look up the state of an
+// entity at a point in time without loading its whole history
+type StateAtQuerier interface {
+	// GetStateAt returns the state and version set by the latest transition
+	// created at or before at, or ErrEntityNotFound if there is none
+	GetStateAt(ctx context.Context, entity Entity, at time.Time) (EntityState, error)
+}
+
+// GetStateAt returns the state entity was in at the given time, set by its
+// latest transition created at or before then. Transitions with the same
+// CreatedAt are ordered by Sequence. It returns ErrEntityNotFound if the
+// entity did not exist yet. If the storage does not implement StateAtQuerier,
+// the state is found in the entity's full history.
+func (f *FSM) GetStateAt(ctx context.Context, entity Entity, at time.Time) (State, error) {
+	if querier, ok := f.storage.(StateAtQuerier); ok {
+		es, err := querier.GetStateAt(ctx, entity, at)
+		if err != nil {
+			return State{}, err
+		}
+		return es.State, nil
+	}
+
+	history, err := f.storage.GetTransitions(ctx, entity)
+	if err != nil {
+		return State{}, err
+	}
+
+	var latest *EntityTransition
+	for i, et := range history {
+		if et.Transition.CreatedAt.After(at) {
+			continue
+		}
+		if latest == nil || !et.Transition.CreatedAt.Before(latest.Transition.CreatedAt) {
+			latest = &history[i]
+		}
+	}
+	if latest == nil {
+		return State{}, ErrEntityNotFound
+	}
+	return latest.Transition.To, nil
+}
+
+// HistoryQuery selects transitions from the history of one entity
+type HistoryQuery struct {
+	Entity Entity
+	// Since and Until restrict the transitions to those created at or after
+	// Since and before Until. Zero values leave the range open.
+	Since time.Time
+	Until time.Time
+	// Events restricts the transitions to those triggered by one of the
+	// given events, matched by name
+	Events []Event
+	// CreatedBy restricts the transitions to those created by the given actor
+	CreatedBy string
+	// Descending returns the newest transitions first
+	Descending bool
+	// Limit is the maximum number of transitions to return,
+	// DefaultQueryLimit if zero
+	Limit int
+	// Cursor continues a previous query from its NextCursor. The query must
+	// otherwise be unchanged.
+	Cursor string
+}
+
+// HistoryPage is one page of QueryHistory results, ordered by Sequence
+type HistoryPage struct {
+	Transitions []EntityTransition
+	// NextCursor fetches the next page, or is empty if this is the last one
+	NextCursor string
+}
+
+// HistoryQuerier is implemented by storage that can filter and paginate the
+// history of an entity without loading all of it
+type HistoryQuerier interface {
+	QueryHistory(ctx context.Context, q HistoryQuery) (HistoryPage, error)
+}
+
+// QueryHistory returns a page of the transitions of q.Entity that match the
+// query. If the storage does not implement HistoryQuerier, the page is
+// selected from the entity's full history.
+func (f *FSM) QueryHistory(ctx context.Context, q HistoryQuery) (HistoryPage, error) {
+	if querier, ok := f.storage.(HistoryQuerier); ok {
+		return querier.QueryHistory(ctx, q)
+	}
+
+	history, err := f.storage.GetTransitions(ctx, q.Entity)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	return selectHistory(history, q)
+}
+
+// selectHistory returns the page of history, ordered by Sequence, that
+// matches q. Only the selected transitions are copied.
+func selectHistory(history []EntityTransition, q HistoryQuery) (HistoryPage, error) {
+	cursor, err := decodeHistoryCursor(q)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	var page HistoryPage
+	for i := range history {
+		et := &history[i]
+		if q.Descending {
+			et = &history[len(history)-1-i]
+		}
+		if cursor != nil && (!q.Descending && et.Sequence <= cursor.Sequence || q.Descending && et.Sequence >= cursor.Sequence) {
+			continue
+		}
+		if !q.matches(et) {
+			continue
+		}
+		if len(page.Transitions) == q.limit() {
+			page.NextCursor = encodeHistoryCursor(q.Descending, page.Transitions[len(page.Transitions)-1])
+			break
+		}
+		page.Transitions = append(page.Transitions, *et)
+	}
+	return page, nil
+}
+
+// matches reports whether et passes the filters of q
+func (q HistoryQuery) matches(et *EntityTransition) bool {
+	t := et.Transition
+	if !inTimeRange(t.CreatedAt, q.Since, q.Until) {
+		return false
+	}
+	if q.CreatedBy != "" && t.CreatedBy != q.CreatedBy {
+		return false
+	}
+	if len(q.Events) > 0 && validateEvent(t.Event, q.Events) != nil {
+		return false
+	}
+	return true
+}
+
+// limit returns the page size of q
+func (q HistoryQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	return q.Limit
+}
+
+// limit returns the page size of q
+func (q StateQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	return q.Limit
+}
+
+// Cursors are JSON positions, base64-encoded behind a prefix naming the kind
+// of query they belong to
+const (
+	stateCursorPrefix   = "s."
+	historyCursorPrefix = "h."
+)
+
+// stateCursor is the position after the last entity of a page
+type stateCursor struct {
+	Order     StateOrder `json:"o"`
+	ID        string     `json:"id"`
+	EnteredAt time.Time  `json:"at,omitzero"`
+}
+
+// historyCursor is the position after the last transition of a page
+type historyCursor struct {
+	Descending bool  `json:"desc,omitempty"`
+	Sequence   int64 `json:"seq"`
+}
+
+// encodeStateCursor returns the cursor continuing after e
+func encodeStateCursor(order StateOrder, e EntityInState) string {
+	c := stateCursor{Order: order, ID: e.Entity.ID}
+	if order != OrderByID {
+		c.EnteredAt = e.EnteredAt
+	}
+	return encodeCursor(stateCursorPrefix, c)
+}
+
+// decodeStateCursor parses q.Cursor, returning nil if it is empty
+func decodeStateCursor(q StateQuery) (*stateCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	var c stateCursor
+	if err := decodeCursor(stateCursorPrefix, q.Cursor, &c); err != nil || c.Order != q.Order {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// encodeHistoryCursor returns the cursor continuing after et
+func encodeHistoryCursor(descending bool, et EntityTransition) string {
+	return encodeCursor(historyCursorPrefix, historyCursor{Descending: descending, Sequence: et.Sequence})
+}
+
+// decodeHistoryCursor parses q.Cursor, returning nil if it is empty
+func decodeHistoryCursor(q HistoryQuery) (*historyCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	var c historyCursor
+	if err := decodeCursor(historyCursorPrefix, q.Cursor, &c); err != nil || c.Descending != q.Descending {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func encodeCursor(prefix string, c any) string {
+	b, _ := json.Marshal(c)
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(prefix, cursor string, c any) error {
+	encoded, ok := strings.CutPrefix(cursor, prefix)
+	if !ok {
+		return ErrInvalidCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
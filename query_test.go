@@ -0,0 +1,156 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFSM_QueryByState(t *testing.T) {
+	states := []State{{Name: "draft"}, {Name: "submitted"}}
+	events := []Event{{Name: "submit"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+	}
+	ctx := context.Background()
+
+	fsm, err := New(states, events, transitions, NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, id := range []string{"inv-1", "inv-2", "inv-3"} {
+		if err := fsm.Start(ctx, Entity{Type: "invoice", ID: id}, State{Name: "draft"}, "user1"); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	}
+	if err := fsm.Trigger(ctx, Entity{Type: "invoice", ID: "inv-2"}, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	page, err := fsm.QueryByState(ctx, StateQuery{Type: "invoice", State: State{Name: "draft"}})
+	if err != nil {
+		t.Fatalf("QueryByState() error = %v", err)
+	}
+	if len(page.Entities) != 2 || page.Entities[0].Entity.ID != "inv-1" || page.Entities[1].Entity.ID != "inv-3" {
+		t.Errorf("QueryByState(draft) = %+v, want inv-1 and inv-3", page.Entities)
+	}
+
+	if _, err := fsm.QueryByState(ctx, StateQuery{Type: "invoice", State: State{Name: "sumbitted"}}); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("QueryByState(unknown state) error = %v, want ErrInvalidState", err)
+	}
+
+	// Storage without the optional interface
+	plain := fsm.WithStorage(struct{ Storage }{NewMemoryStorage()})
+	if _, err := plain.QueryByState(ctx, StateQuery{Type: "invoice", State: State{Name: "draft"}}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("QueryByState() on plain storage error = %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestFSM_GetStateAt(t *testing.T) {
+	states := []State{{Name: "draft"}, {Name: "signed"}}
+	events := []Event{{Name: "sign"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "signed"}, Event: Event{Name: "sign"}},
+	}
+	ctx := context.Background()
+	base := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	entity := Entity{Type: "contract", ID: "c-1"}
+
+	// A clock skewed backwards records the third transition before the second
+	history := []EntityTransition{
+		{Entity: entity, Transition: Transition{To: State{Name: "draft"}, CreatedAt: base}},
+		{Entity: entity, Transition: Transition{From: State{Name: "draft"}, To: State{Name: "signed"}, CreatedAt: base.Add(2 * time.Hour)}},
+		{Entity: entity, Transition: Transition{From: State{Name: "signed"}, To: State{Name: "draft"}, CreatedAt: base.Add(time.Hour)}},
+	}
+
+	for name, storage := range map[string]Storage{
+		"StateAtQuerier": NewMemoryStorage(),
+		"GetTransitions": struct{ Storage }{NewMemoryStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, et := range history {
+				if err := storage.SaveTransition(ctx, et); err != nil {
+					t.Fatalf("SaveTransition() error = %v", err)
+				}
+			}
+			fsm, err := New(states, events, transitions, storage)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if _, err := fsm.GetStateAt(ctx, entity, base.Add(-time.Minute)); !errors.Is(err, ErrEntityNotFound) {
+				t.Errorf("GetStateAt(before start) error = %v, want ErrEntityNotFound", err)
+			}
+			for _, tt := range []struct {
+				at   time.Duration
+				want string
+			}{
+				{0, "draft"},
+				{90 * time.Minute, "draft"},
+				{3 * time.Hour, "signed"},
+			} {
+				state, err := fsm.GetStateAt(ctx, entity, base.Add(tt.at))
+				if err != nil || state.Name != tt.want {
+					t.Errorf("GetStateAt(+%v) = %q, %v, want %q", tt.at, state.Name, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFSM_QueryHistory(t *testing.T) {
+	states := []State{{Name: "draft"}, {Name: "submitted"}}
+	events := []Event{{Name: "submit"}, {Name: "revise"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+		{From: State{Name: "submitted"}, To: State{Name: "draft"}, Event: Event{Name: "revise"}},
+	}
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-1"}
+
+	for name, storage := range map[string]Storage{
+		"HistoryQuerier": NewMemoryStorage(),
+		"GetTransitions": struct{ Storage }{NewMemoryStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsm, err := New(states, events, transitions, storage)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := fsm.Start(ctx, entity, State{Name: "draft"}, "alice"); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				for _, event := range []string{"submit", "revise"} {
+					if err := fsm.Trigger(ctx, entity, Event{Name: event}, "alice"); err != nil {
+						t.Fatalf("Trigger(%s) error = %v", event, err)
+					}
+				}
+			}
+
+			q := HistoryQuery{Entity: entity, Events: []Event{{Name: "submit"}}, Descending: true, Limit: 3}
+			page, err := fsm.QueryHistory(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryHistory() error = %v", err)
+			}
+			if len(page.Transitions) != 3 || page.Transitions[0].Sequence != 10 || page.Transitions[2].Sequence != 6 {
+				t.Errorf("QueryHistory() = %v, want the submits with sequences 10, 8 and 6", page.Transitions)
+			}
+
+			q.Cursor = page.NextCursor
+			page, err = fsm.QueryHistory(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryHistory(next page) error = %v", err)
+			}
+			if len(page.Transitions) != 2 || page.Transitions[1].Sequence != 2 || page.NextCursor != "" {
+				t.Errorf("QueryHistory(next page) = %v, %q, want the submits with sequences 4 and 2 and no cursor", page.Transitions, page.NextCursor)
+			}
+
+			q.Cursor = encodeStateCursor(OrderByID, EntityInState{})
+			if _, err := fsm.QueryHistory(ctx, q); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("QueryHistory(state cursor) error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
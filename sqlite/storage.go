@@ -0,0 +1,300 @@
+// Package sqlite provides a SQLite implementation of fsm.Storage. It uses the
+// pure-Go modernc.org/sqlite driver, so it builds without cgo.
+//
+// It lives in its own module so that applications using the in-memory or
+// PostgreSQL storage do not pull in the SQLite driver.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	fsm "github.com/tendant/simple-fsm"
+	_ "modernc.org/sqlite"
+)
+
+// schema mirrors the PostgreSQL migrations in the migrations directory
+const schema = `
+CREATE TABLE IF NOT EXISTS entity_state_transition (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    from_state TEXT,
+    to_state TEXT NOT NULL,
+    event TEXT NOT NULL,
+    created_by TEXT,
+    version INTEGER NOT NULL,
+    event_payload TEXT,
+    metadata TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_entity_state_transition_entity
+    ON entity_state_transition(entity_type, entity_id, created_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_entity_state_transition_created_at
+    ON entity_state_transition(created_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_entity_state_transition_version
+    ON entity_state_transition(entity_type, entity_id, version);
+`
+
+// timeLayout stores timestamps as fixed-width UTC text so that they sort
+// chronologically
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Storage implements fsm.Storage using SQLite
+type Storage struct {
+	db *sql.DB
+}
+
+// NewStorage opens the SQLite database at path, creating it and the
+// entity_state_transition table if they do not exist
+func NewStorage(ctx context.Context, path string) (*Storage, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return &Storage{
+		db: db,
+	}, nil
+}
+
+// Close closes the database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// SaveTransition saves a state transition to SQLite
+func (s *Storage) SaveTransition(ctx context.Context, et fsm.EntityTransition) error {
+	query := `
+		INSERT INTO entity_state_transition
+		(entity_type, entity_id, from_state, to_state, event, created_by, created_at, event_payload, metadata, version)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9,
+			COALESCE(MAX(version), 0) + 1
+		FROM entity_state_transition
+		WHERE entity_type = ?1 AND entity_id = ?2
+	`
+
+	args, err := transitionArgs(et)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("failed to save transition: %w", fsm.ErrConcurrentModification)
+		}
+		return fmt.Errorf("failed to save transition: %w", err)
+	}
+
+	return nil
+}
+
+// CompareAndSaveTransition saves a state transition to SQLite if the entity is
+// still in the expected state and version. The check and the insert run as a
+// single statement, which SQLite executes under its write lock.
+func (s *Storage) CompareAndSaveTransition(ctx context.Context, expected fsm.EntityState, et fsm.EntityTransition) error {
+	query := `
+		INSERT INTO entity_state_transition
+		(entity_type, entity_id, from_state, to_state, event, created_by, created_at, event_payload, metadata, version)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?11 + 1
+		WHERE (
+			SELECT COALESCE(MAX(version), 0)
+			FROM entity_state_transition
+			WHERE entity_type = ?1 AND entity_id = ?2
+		) = ?11
+		AND COALESCE((
+			SELECT to_state
+			FROM entity_state_transition
+			WHERE entity_type = ?1 AND entity_id = ?2 AND version = ?11
+		), '') = ?10
+	`
+
+	args, err := transitionArgs(et)
+	if err != nil {
+		return err
+	}
+	args = append(args, expected.State.Name, expected.Version)
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fsm.ErrConcurrentModification
+		}
+		return fmt.Errorf("failed to save transition: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to save transition: %w", err)
+	}
+	if n == 0 {
+		return fsm.ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// GetCurrentState retrieves the current state of an entity from SQLite
+func (s *Storage) GetCurrentState(ctx context.Context, entity fsm.Entity) (fsm.State, error) {
+	es, err := s.GetEntityState(ctx, entity)
+	if err != nil {
+		return fsm.State{}, err
+	}
+	return es.State, nil
+}
+
+// GetEntityState retrieves the current state and version of an entity from SQLite
+func (s *Storage) GetEntityState(ctx context.Context, entity fsm.Entity) (fsm.EntityState, error) {
+	query := `
+		SELECT to_state, version
+		FROM entity_state_transition
+		WHERE entity_type = ?1 AND entity_id = ?2
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	var (
+		stateName string
+		version   int64
+	)
+	err := s.db.QueryRowContext(ctx, query, entity.Type, entity.ID).Scan(&stateName, &version)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fsm.EntityState{}, fsm.ErrEntityNotFound
+		}
+		return fsm.EntityState{}, fmt.Errorf("failed to get entity state: %w", err)
+	}
+
+	return fsm.EntityState{
+		Entity:  entity,
+		State:   fsm.State{Name: stateName},
+		Version: version,
+	}, nil
+}
+
+// GetTransitions retrieves all transitions for an entity from SQLite
+func (s *Storage) GetTransitions(ctx context.Context, entity fsm.Entity) ([]fsm.EntityTransition, error) {
+	query := `
+		SELECT from_state, to_state, event, created_by, created_at, event_payload, metadata, version
+		FROM entity_state_transition
+		WHERE entity_type = ?1 AND entity_id = ?2
+		ORDER BY version ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, entity.Type, entity.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []fsm.EntityTransition
+	for rows.Next() {
+		var (
+			fromState sql.NullString
+			toState   string
+			event     string
+			createdBy sql.NullString
+			createdAt string
+			payload   sql.NullString
+			metadata  sql.NullString
+			sequence  int64
+		)
+
+		err := rows.Scan(&fromState, &toState, &event, &createdBy, &createdAt, &payload, &metadata, &sequence)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transition row: %w", err)
+		}
+
+		at, err := time.Parse(time.RFC3339Nano, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse created_at: %w", err)
+		}
+
+		t := fsm.Transition{
+			From:      fsm.State{Name: fromState.String},
+			To:        fsm.State{Name: toState},
+			Event:     fsm.Event{Name: event},
+			CreatedBy: createdBy.String,
+			CreatedAt: at,
+		}
+		if payload.Valid {
+			if err := json.Unmarshal([]byte(payload.String), &t.Event.Payload); err != nil {
+				return nil, fmt.Errorf("failed to decode event payload: %w", err)
+			}
+		}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &t.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode transition metadata: %w", err)
+			}
+		}
+
+		transitions = append(transitions, fsm.EntityTransition{
+			Entity:     entity,
+			Transition: t,
+			Sequence:   sequence,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transition rows: %w", err)
+	}
+
+	return transitions, nil
+}
+
+// transitionArgs returns the parameters ?1 to ?9 shared by the insert
+// statements, encoding the event payload and metadata as JSON and leaving nil
+// maps as SQL NULL
+func transitionArgs(et fsm.EntityTransition) ([]any, error) {
+	var payload, metadata sql.NullString
+	if et.Transition.Event.Payload != nil {
+		b, err := json.Marshal(et.Transition.Event.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event payload: %w", err)
+		}
+		payload = sql.NullString{String: string(b), Valid: true}
+	}
+	if et.Transition.Metadata != nil {
+		b, err := json.Marshal(et.Transition.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode transition metadata: %w", err)
+		}
+		metadata = sql.NullString{String: string(b), Valid: true}
+	}
+
+	return []any{
+		et.Entity.Type,
+		et.Entity.ID,
+		et.Transition.From.Name,
+		et.Transition.To.Name,
+		et.Transition.Event.Name,
+		et.Transition.CreatedBy,
+		et.Transition.CreatedAt.UTC().Format(timeLayout),
+		payload,
+		metadata,
+	}, nil
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
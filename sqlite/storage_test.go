@@ -0,0 +1,279 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fsm "github.com/tendant/simple-fsm"
+	"github.com/tendant/simple-fsm/fsmtest"
+)
+
+func setupTestDB(t *testing.T) *Storage {
+	t.Helper()
+
+	storage, err := NewStorage(context.Background(), filepath.Join(t.TempDir(), "fsm.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+
+	return storage
+}
+
+func TestStorage_GetCurrentState(t *testing.T) {
+	storage := setupTestDB(t)
+	ctx := context.Background()
+	entity := fsm.Entity{Type: "document", ID: "doc-1"}
+
+	_, err := storage.GetCurrentState(ctx, entity)
+	if !errors.Is(err, fsm.ErrEntityNotFound) {
+		t.Errorf("GetCurrentState() error = %v, want ErrEntityNotFound", err)
+	}
+
+	transitions := []fsm.EntityTransition{
+		{
+			Entity: entity,
+			Transition: fsm.Transition{
+				From:      fsm.State{Name: ""},
+				To:        fsm.State{Name: "draft"},
+				Event:     fsm.Event{Name: "start"},
+				CreatedBy: "user1",
+				CreatedAt: time.Now().UTC().Add(-1 * time.Hour),
+			},
+		},
+		{
+			Entity: entity,
+			Transition: fsm.Transition{
+				From:      fsm.State{Name: "draft"},
+				To:        fsm.State{Name: "submitted"},
+				Event:     fsm.Event{Name: "submit"},
+				CreatedBy: "user1",
+				CreatedAt: time.Now().UTC(),
+			},
+		},
+	}
+
+	for _, tr := range transitions {
+		if err := storage.SaveTransition(ctx, tr); err != nil {
+			t.Fatalf("SaveTransition() error = %v", err)
+		}
+	}
+
+	state, err := storage.GetCurrentState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetCurrentState() error = %v", err)
+	}
+	if state.Name != "submitted" {
+		t.Errorf("GetCurrentState() = %v, want submitted", state.Name)
+	}
+
+	es, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+	if es.Version != 2 {
+		t.Errorf("GetEntityState() version = %v, want 2", es.Version)
+	}
+}
+
+func TestStorage_GetTransitions(t *testing.T) {
+	storage := setupTestDB(t)
+	ctx := context.Background()
+	entity := fsm.Entity{Type: "document", ID: "doc-2"}
+	createdAt := time.Date(2025, 11, 4, 22, 0, 0, 123456789, time.UTC)
+
+	transitions := []fsm.EntityTransition{
+		{
+			Entity: entity,
+			Transition: fsm.Transition{
+				From:      fsm.State{Name: ""},
+				To:        fsm.State{Name: "submitted"},
+				Event:     fsm.Event{Name: "start"},
+				CreatedBy: "user1",
+				CreatedAt: createdAt,
+			},
+		},
+		{
+			Entity: entity,
+			Transition: fsm.Transition{
+				From:      fsm.State{Name: "submitted"},
+				To:        fsm.State{Name: "rejected"},
+				Event:     fsm.Event{Name: "reject", Payload: map[string]any{"reason": "missing signature", "pages": 3}},
+				CreatedBy: "user2",
+				CreatedAt: createdAt.Add(time.Minute),
+				Metadata:  map[string]any{"comment": "please sign"},
+			},
+		},
+	}
+
+	for _, tr := range transitions {
+		if err := storage.SaveTransition(ctx, tr); err != nil {
+			t.Fatalf("SaveTransition() error = %v", err)
+		}
+	}
+
+	// Another entity's history is not included
+	other := fsm.EntityTransition{Entity: fsm.Entity{Type: "document", ID: "doc-3"}, Transition: transitions[0].Transition}
+	if err := storage.SaveTransition(ctx, other); err != nil {
+		t.Fatalf("SaveTransition() error = %v", err)
+	}
+
+	result, err := storage.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("GetTransitions() count = %v, want 2", len(result))
+	}
+
+	first := result[0].Transition
+	if first.To.Name != "submitted" || first.CreatedBy != "user1" || !first.CreatedAt.Equal(createdAt) {
+		t.Errorf("first transition = %+v, want start to submitted at %v", first, createdAt)
+	}
+	if first.Event.Payload != nil || first.Metadata != nil {
+		t.Errorf("first transition payload = %v metadata = %v, want nil", first.Event.Payload, first.Metadata)
+	}
+
+	second := result[1].Transition
+	if second.Event.Payload["reason"] != "missing signature" || second.Event.Payload["pages"] != float64(3) {
+		t.Errorf("event payload = %v, want reason and pages", second.Event.Payload)
+	}
+	if second.Metadata["comment"] != "please sign" {
+		t.Errorf("metadata = %v, want comment", second.Metadata)
+	}
+}
+
+func TestStorage_CompareAndSaveTransition(t *testing.T) {
+	storage := setupTestDB(t)
+	ctx := context.Background()
+	entity := fsm.Entity{Type: "document", ID: "doc-cas"}
+
+	start := fsm.EntityTransition{
+		Entity: entity,
+		Transition: fsm.Transition{
+			To:        fsm.State{Name: "draft"},
+			Event:     fsm.Event{Name: "start"},
+			CreatedBy: "user1",
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+
+	if err := storage.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, start); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	// Creating it again conflicts
+	err := storage.CompareAndSaveTransition(ctx, fsm.EntityState{Entity: entity}, start)
+	if !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition() error = %v, want ErrConcurrentModification", err)
+	}
+
+	current, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+
+	submit := fsm.EntityTransition{
+		Entity: entity,
+		Transition: fsm.Transition{
+			From:      fsm.State{Name: "draft"},
+			To:        fsm.State{Name: "submitted"},
+			Event:     fsm.Event{Name: "submit"},
+			CreatedBy: "user1",
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+
+	// Stale state is rejected
+	stale := fsm.EntityState{Entity: entity, State: fsm.State{Name: "submitted"}, Version: 1}
+	if err := storage.CompareAndSaveTransition(ctx, stale, submit); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale state) error = %v, want ErrConcurrentModification", err)
+	}
+
+	if err := storage.CompareAndSaveTransition(ctx, current, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	// The same version cannot be written twice
+	if err := storage.CompareAndSaveTransition(ctx, current, submit); !errors.Is(err, fsm.ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale version) error = %v, want ErrConcurrentModification", err)
+	}
+}
+
+func TestStorage_WithFSM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fsm.db")
+	ctx := context.Background()
+
+	storage, err := NewStorage(ctx, path)
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+
+	states := []fsm.State{{Name: "draft"}, {Name: "submitted"}, {Name: "approved"}}
+	events := []fsm.Event{{Name: "submit"}, {Name: "approve"}}
+	transitions := []fsm.Transition{
+		{From: fsm.State{Name: "draft"}, To: fsm.State{Name: "submitted"}, Event: fsm.Event{Name: "submit"}},
+		{From: fsm.State{Name: "submitted"}, To: fsm.State{Name: "approved"}, Event: fsm.Event{Name: "approve"}},
+	}
+
+	machine, err := fsm.New(states, events, transitions, storage)
+	if err != nil {
+		t.Fatalf("Failed to create FSM: %v", err)
+	}
+
+	entity := fsm.Entity{Type: "document", ID: "doc-4"}
+	if err := machine.Start(ctx, entity, fsm.State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Only one of several concurrent triggers wins
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = machine.Trigger(ctx, entity, fsm.Event{Name: "submit"}, "user1")
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("concurrent Trigger() succeeded %d times, want 1 (errors: %v)", succeeded, errs)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The history survives reopening the database
+	storage, err = NewStorage(ctx, path)
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+	defer storage.Close()
+
+	history, err := storage.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(history) != 2 || history[1].Transition.To.Name != "submitted" {
+		t.Errorf("GetTransitions() = %v, want start and submit", history)
+	}
+}
+
+func TestStorage_Suite(t *testing.T) {
+	fsmtest.RunStorageSuite(t, func(t *testing.T) fsm.Storage {
+		return setupTestDB(t)
+	})
+}
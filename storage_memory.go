@@ -1,64 +1,572 @@
 package fsm
 
 import (
+	"bytes"
+	"cmp"
+	"container/heap"
 	"context"
+	"encoding/json"
 	"errors"
+	"hash/maphash"
+	"iter"
+	"slices"
 	"sync"
+	"time"
 )
 
 var (
 	ErrEntityNotFound = errors.New("entity not found")
 )
 
-// MemoryStorage implements Storage interface using in-memory data structures
+// MemoryStorage implements Storage interface using in-memory data structures.
+// History and current state are indexed by entity, so lookups do not depend
+// on the number of entities stored.
 type MemoryStorage struct {
-	mu          sync.RWMutex
+	seed   maphash.Seed
+	shards []*memoryShard
+}
+
+// memoryShard holds the entities whose hash maps to it, under its own lock
+type memoryShard struct {
+	mu       sync.RWMutex
+	entities map[Entity]*memoryEntity
+	// byState indexes the entities by type and current state
+	byState map[memoryStateKey]map[string]*memoryEntity
+}
+
+type memoryStateKey struct {
+	entityType string
+	state      string
+}
+
+// memoryEntity is the history of one entity; its current state is the last
+// transition and its version the number of transitions
+type memoryEntity struct {
 	transitions []EntityTransition
+	// byTime indexes transitions in order of CreatedAt, then Sequence
+	byTime []int
+	// data is the entity's extended state data
+	data json.RawMessage
+}
+
+// countAt returns the number of transitions created at or before at
+func (e *memoryEntity) countAt(at time.Time) int {
+	i, _ := slices.BinarySearchFunc(e.byTime, at, func(idx int, at time.Time) int {
+		if e.transitions[idx].Transition.CreatedAt.After(at) {
+			return 1
+		}
+		return -1
+	})
+	return i
+}
+
+// countBefore returns the number of transitions created before at
+func (e *memoryEntity) countBefore(at time.Time) int {
+	i, _ := slices.BinarySearchFunc(e.byTime, at, func(idx int, at time.Time) int {
+		if e.transitions[idx].Transition.CreatedAt.Before(at) {
+			return -1
+		}
+		return 1
+	})
+	return i
+}
+
+func (e *memoryEntity) state() EntityState {
+	last := e.transitions[len(e.transitions)-1]
+	return EntityState{
+		Entity:  last.Entity,
+		State:   last.Transition.To,
+		Version: int64(len(e.transitions)),
+	}
+}
+
+// MemoryOption configures a MemoryStorage
+type MemoryOption func(*MemoryStorage)
+
+// WithMemoryShards splits the storage into n independently locked shards, so
+// that writers to different entities rarely wait on each other. The default
+// is a single shard.
+func WithMemoryShards(n int) MemoryOption {
+	return func(m *MemoryStorage) {
+		if n < 1 {
+			n = 1
+		}
+		m.shards = make([]*memoryShard, n)
+	}
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
-func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{
-		transitions: make([]EntityTransition, 0),
+func NewMemoryStorage(opts ...MemoryOption) *MemoryStorage {
+	m := &MemoryStorage{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*memoryShard, 1),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			entities: make(map[Entity]*memoryEntity),
+			byState:  make(map[memoryStateKey]map[string]*memoryEntity),
+		}
 	}
+	return m
+}
+
+// shard returns the shard holding entity
+func (m *MemoryStorage) shard(entity Entity) *memoryShard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	var h maphash.Hash
+	h.SetSeed(m.seed)
+	h.WriteString(entity.Type)
+	h.WriteByte(0)
+	h.WriteString(entity.ID)
+	return m.shards[h.Sum64()%uint64(len(m.shards))]
 }
 
 // SaveTransition saves a transition to memory
 func (m *MemoryStorage) SaveTransition(ctx context.Context, et EntityTransition) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := m.shard(et.Entity)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.append(et)
+	return nil
+}
+
+// CompareAndSaveTransition saves a transition to memory if the entity is
+// still in the expected state and version
+func (m *MemoryStorage) CompareAndSaveTransition(ctx context.Context, expected EntityState, et EntityTransition) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := m.shard(et.Entity)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := EntityState{Entity: et.Entity}
+	if e := s.entities[et.Entity]; e != nil {
+		current = e.state()
+	}
+	if current.Version != expected.Version || current.State.Name != expected.State.Name {
+		return ErrConcurrentModification
+	}
+
+	s.append(et)
+	return nil
+}
+
+// CompareAndSaveTransitions saves transitions to memory, all or none, if the
+// entity is still in the expected state and version
+func (m *MemoryStorage) CompareAndSaveTransitions(ctx context.Context, expected EntityState, ets []EntityTransition) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := m.shard(expected.Entity)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := EntityState{Entity: expected.Entity}
+	if e := s.entities[expected.Entity]; e != nil {
+		current = e.state()
+	}
+	if current.Version != expected.Version || current.State.Name != expected.State.Name {
+		return ErrConcurrentModification
+	}
 
-	m.transitions = append(m.transitions, et)
+	for _, et := range ets {
+		s.append(et)
+	}
 	return nil
 }
 
+// append records et as the entity's next transition. Caller must hold s.mu.
+func (s *memoryShard) append(et EntityTransition) {
+	e := s.entities[et.Entity]
+	if e == nil {
+		e = &memoryEntity{}
+		s.entities[et.Entity] = e
+	} else {
+		key := memoryStateKey{et.Entity.Type, e.state().State.Name}
+		delete(s.byState[key], et.Entity.ID)
+		if len(s.byState[key]) == 0 {
+			delete(s.byState, key)
+		}
+	}
+	if et.Data != nil {
+		e.data = bytes.Clone(et.Data)
+		et.Data = nil
+	}
+	et = cloneTransition(et)
+	et.Sequence = int64(len(e.transitions)) + 1
+	e.transitions = append(e.transitions, et)
+
+	// Timestamps normally increase, so this appends to byTime. Inserting
+	// before the end copies byTime instead of shifting it in place, which
+	// would change it under the cursors of IterAllTransitions.
+	i := e.countAt(et.Transition.CreatedAt)
+	if i < len(e.byTime) {
+		e.byTime = slices.Clip(e.byTime)
+	}
+	e.byTime = slices.Insert(e.byTime, i, len(e.transitions)-1)
+
+	key := memoryStateKey{et.Entity.Type, et.Transition.To.Name}
+	if s.byState[key] == nil {
+		s.byState[key] = make(map[string]*memoryEntity)
+	}
+	s.byState[key][et.Entity.ID] = e
+}
+
+// cloneTransition returns a copy of et that shares no maps or slices with it,
+// so neither the caller saving a transition nor one reading it back can change
+// the stored history
+func cloneTransition(et EntityTransition) EntityTransition {
+	et.Transition.Event.Payload = cloneMap(et.Transition.Event.Payload)
+	et.Transition.Metadata = cloneMap(et.Transition.Metadata)
+	et.Regions = slices.Clone(et.Regions)
+	return et
+}
+
+// cloneMap deep-copies m, including the maps and slices nested in it as
+// decoded from JSON
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		c[k] = cloneValue(v)
+	}
+	return c
+}
+
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneMap(v)
+	case []any:
+		if v == nil {
+			return v
+		}
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = cloneValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
 // GetCurrentState retrieves the current state of an entity
 func (m *MemoryStorage) GetCurrentState(ctx context.Context, entity Entity) (State, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	// Find the most recent transition for this entity
-	for i := len(m.transitions) - 1; i >= 0; i-- {
-		t := m.transitions[i]
-		if t.Entity.Type == entity.Type && t.Entity.ID == entity.ID {
-			return t.Transition.To, nil
-		}
+	es, err := m.GetEntityState(ctx, entity)
+	if err != nil {
+		return State{}, err
 	}
+	return es.State, nil
+}
+
+// GetEntityState retrieves the current state and version of an entity
+func (m *MemoryStorage) GetEntityState(ctx context.Context, entity Entity) (EntityState, error) {
+	if err := ctx.Err(); err != nil {
+		return EntityState{}, err
+	}
+
+	s := m.shard(entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return State{}, ErrEntityNotFound
+	e := s.entities[entity]
+	if e == nil {
+		return EntityState{}, ErrEntityNotFound
+	}
+	return e.state(), nil
+}
+
+// GetEntityData retrieves the current state and version of an entity
+// together with its data
+func (m *MemoryStorage) GetEntityData(ctx context.Context, entity Entity) (EntityState, json.RawMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return EntityState{}, nil, err
+	}
+
+	s := m.shard(entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.entities[entity]
+	if e == nil {
+		return EntityState{}, nil, ErrEntityNotFound
+	}
+	return e.state(), bytes.Clone(e.data), nil
+}
+
+// GetEntityRegions retrieves the current state and version of an entity
+// together with the states of its regions
+func (m *MemoryStorage) GetEntityRegions(ctx context.Context, entity Entity) (EntityState, []State, error) {
+	if err := ctx.Err(); err != nil {
+		return EntityState{}, nil, err
+	}
+
+	s := m.shard(entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.entities[entity]
+	if e == nil {
+		return EntityState{}, nil, ErrEntityNotFound
+	}
+	return e.state(), slices.Clone(e.transitions[len(e.transitions)-1].Regions), nil
 }
 
-// GetTransitions retrieves all transitions for an entity
+// GetTransitions retrieves all transitions for an entity in the order they
+// were saved
 func (m *MemoryStorage) GetTransitions(ctx context.Context, entity Entity) ([]EntityTransition, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	var result []EntityTransition
-	for _, t := range m.transitions {
-		if t.Entity.Type == entity.Type && t.Entity.ID == entity.ID {
-			result = append(result, t)
-		}
+	s := m.shard(entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.entities[entity]
+	if e == nil {
+		return nil, nil
 	}
 
+	result := make([]EntityTransition, len(e.transitions))
+	for i, et := range e.transitions {
+		result[i] = cloneTransition(et)
+	}
 	return result, nil
 }
+
+// GetStateAt returns the state and version set by the latest transition of
+// entity created at or before at
+func (m *MemoryStorage) GetStateAt(ctx context.Context, entity Entity, at time.Time) (EntityState, error) {
+	if err := ctx.Err(); err != nil {
+		return EntityState{}, err
+	}
+
+	s := m.shard(entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.entities[entity]
+	if e == nil {
+		return EntityState{}, ErrEntityNotFound
+	}
+	i := e.countAt(at)
+	if i == 0 {
+		return EntityState{}, ErrEntityNotFound
+	}
+
+	et := e.transitions[e.byTime[i-1]]
+	return EntityState{
+		Entity:  entity,
+		State:   et.Transition.To,
+		Version: et.Sequence,
+	}, nil
+}
+
+// QueryHistory returns a page of the transitions of q.Entity that match the
+// query, copying only the transitions on the page
+func (m *MemoryStorage) QueryHistory(ctx context.Context, q HistoryQuery) (HistoryPage, error) {
+	if err := ctx.Err(); err != nil {
+		return HistoryPage{}, err
+	}
+
+	s := m.shard(q.Entity)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var history []EntityTransition
+	if e := s.entities[q.Entity]; e != nil {
+		history = e.transitions
+	}
+	page, err := selectHistory(history, q)
+	for i, et := range page.Transitions {
+		page.Transitions[i] = cloneTransition(et)
+	}
+	return page, err
+}
+
+// IterTransitions yields the history of entity in order of Sequence. Saved
+// transitions never change, so it iterates over the history as it was when
+// iteration started, without holding a lock; each transition is copied as it
+// is yielded.
+func (m *MemoryStorage) IterTransitions(ctx context.Context, entity Entity) iter.Seq2[EntityTransition, error] {
+	return func(yield func(EntityTransition, error) bool) {
+		if err := ctx.Err(); err != nil {
+			yield(EntityTransition{}, err)
+			return
+		}
+
+		s := m.shard(entity)
+		s.mu.RLock()
+		var history []EntityTransition
+		if e := s.entities[entity]; e != nil {
+			history = e.transitions
+		}
+		s.mu.RUnlock()
+
+		for _, et := range history {
+			if !yield(cloneTransition(et), nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterAllTransitions yields the transitions of every entity created at or
+// after since and before until, in order of CreatedAt. Transitions with the
+// same CreatedAt are ordered by entity and Sequence. The histories of the
+// entities, already indexed by CreatedAt, are merged as the loop consumes
+// them, so memory use grows with the number of entities rather than the
+// number of transitions.
+func (m *MemoryStorage) IterAllTransitions(ctx context.Context, since, until time.Time) iter.Seq2[EntityTransition, error] {
+	return func(yield func(EntityTransition, error) bool) {
+		if err := ctx.Err(); err != nil {
+			yield(EntityTransition{}, err)
+			return
+		}
+
+		var merge memoryMerge
+		for _, s := range m.shards {
+			s.mu.RLock()
+			for _, e := range s.entities {
+				c := &memoryCursor{transitions: e.transitions, byTime: e.byTime, end: len(e.byTime)}
+				if !since.IsZero() {
+					c.next = e.countBefore(since)
+				}
+				if !until.IsZero() {
+					c.end = e.countBefore(until)
+				}
+				if c.next < c.end {
+					merge = append(merge, c)
+				}
+			}
+			s.mu.RUnlock()
+		}
+		heap.Init(&merge)
+
+		for len(merge) > 0 {
+			if err := ctx.Err(); err != nil {
+				yield(EntityTransition{}, err)
+				return
+			}
+			c := merge[0]
+			et := cloneTransition(*c.head())
+			if c.next++; c.next < c.end {
+				heap.Fix(&merge, 0)
+			} else {
+				heap.Pop(&merge)
+			}
+			if !yield(et, nil) {
+				return
+			}
+		}
+	}
+}
+
+// memoryCursor walks the transitions of one entity in order of CreatedAt,
+// over the history as it was when the cursor was created
+type memoryCursor struct {
+	transitions []EntityTransition
+	byTime      []int
+	next, end   int
+}
+
+func (c *memoryCursor) head() *EntityTransition {
+	return &c.transitions[c.byTime[c.next]]
+}
+
+// memoryMerge is a heap of cursors ordered by their next transition
+type memoryMerge []*memoryCursor
+
+func (h memoryMerge) Len() int      { return len(h) }
+func (h memoryMerge) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h memoryMerge) Less(i, j int) bool {
+	a, b := h[i].head(), h[j].head()
+	return cmp.Or(
+		a.Transition.CreatedAt.Compare(b.Transition.CreatedAt),
+		cmp.Compare(a.Entity.Type, b.Entity.Type),
+		cmp.Compare(a.Entity.ID, b.Entity.ID),
+		cmp.Compare(a.Sequence, b.Sequence),
+	) < 0
+}
+func (h *memoryMerge) Push(x any) { *h = append(*h, x.(*memoryCursor)) }
+func (h *memoryMerge) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// QueryByState lists the entities of q.Type currently in q.State
+func (m *MemoryStorage) QueryByState(ctx context.Context, q StateQuery) (StatePage, error) {
+	if err := ctx.Err(); err != nil {
+		return StatePage{}, err
+	}
+	cursor, err := decodeStateCursor(q)
+	if err != nil {
+		return StatePage{}, err
+	}
+
+	var matches []EntityInState
+	key := memoryStateKey{q.Type, q.State.Name}
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for _, e := range s.byState[key] {
+			matches = append(matches, EntityInState{
+				EntityState: e.state(),
+				EnteredAt:   e.transitions[len(e.transitions)-1].Transition.CreatedAt,
+			})
+		}
+		s.mu.RUnlock()
+	}
+
+	slices.SortFunc(matches, func(a, b EntityInState) int {
+		return compareInState(q.Order, a, b)
+	})
+	if cursor != nil {
+		after := EntityInState{EntityState: EntityState{Entity: Entity{ID: cursor.ID}}, EnteredAt: cursor.EnteredAt}
+		i, _ := slices.BinarySearchFunc(matches, after, func(e, after EntityInState) int {
+			if compareInState(q.Order, e, after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		matches = matches[i:]
+	}
+
+	var page StatePage
+	if len(matches) > q.limit() {
+		matches = matches[:q.limit()]
+		page.NextCursor = encodeStateCursor(q.Order, matches[len(matches)-1])
+	}
+	page.Entities = matches
+	return page, nil
+}
+
+// compareInState compares entities in the given order
+func compareInState(order StateOrder, a, b EntityInState) int {
+	switch order {
+	case OrderByEnteredAt:
+		return cmp.Or(a.EnteredAt.Compare(b.EnteredAt), cmp.Compare(a.Entity.ID, b.Entity.ID))
+	case OrderByEnteredAtDesc:
+		return cmp.Or(b.EnteredAt.Compare(a.EnteredAt), cmp.Compare(b.Entity.ID, a.Entity.ID))
+	default:
+		return cmp.Compare(a.Entity.ID, b.Entity.ID)
+	}
+}
@@ -0,0 +1,125 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// populateMemoryStorage starts n entities with two transitions each
+func populateMemoryStorage(b *testing.B, storage *MemoryStorage, n int) []Entity {
+	b.Helper()
+	ctx := context.Background()
+
+	entities := make([]Entity, n)
+	for i := range entities {
+		entities[i] = Entity{Type: "document", ID: fmt.Sprintf("doc-%d", i)}
+		for _, to := range []string{"draft", "submitted"} {
+			et := EntityTransition{Entity: entities[i], Transition: Transition{To: State{Name: to}}}
+			if err := storage.SaveTransition(ctx, et); err != nil {
+				b.Fatalf("SaveTransition() error = %v", err)
+			}
+		}
+	}
+	return entities
+}
+
+func BenchmarkMemoryStorage_GetCurrentState(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("entities=%d", n), func(b *testing.B) {
+			storage := NewMemoryStorage()
+			entities := populateMemoryStorage(b, storage, n)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.GetCurrentState(ctx, entities[i%n]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryStorage_GetTransitions(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("entities=%d", n), func(b *testing.B) {
+			storage := NewMemoryStorage()
+			entities := populateMemoryStorage(b, storage, n)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.GetTransitions(ctx, entities[i%n]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryStorage_Trigger(b *testing.B) {
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+		{From: State{Name: "submitted"}, To: State{Name: "draft"}, Event: Event{Name: "revise"}},
+	}
+	events := []Event{{Name: "submit"}, {Name: "revise"}}
+	states := []State{{Name: "draft"}, {Name: "submitted"}}
+
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			storage := NewMemoryStorage(WithMemoryShards(shards))
+			entities := populateMemoryStorage(b, storage, 10000)
+			fsm, err := New(states, events, transitions, storage)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Each goroutine works through its own range of entities,
+				// moving each one to draft and back to submitted
+				offset := int(next.Add(1)) * 1000
+				for i := 0; pb.Next(); i++ {
+					entity := entities[(offset+i)%len(entities)]
+					_ = fsm.Trigger(ctx, entity, Event{Name: "revise"}, "bench")
+					_ = fsm.Trigger(ctx, entity, Event{Name: "submit"}, "bench")
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStorage_Shards(t *testing.T) {
+	storage := NewMemoryStorage(WithMemoryShards(8))
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		entity := Entity{Type: "document", ID: fmt.Sprintf("doc-%d", i)}
+		for _, to := range []string{"draft", "submitted", "approved"} {
+			if err := storage.SaveTransition(ctx, EntityTransition{Entity: entity, Transition: Transition{To: State{Name: to}}}); err != nil {
+				t.Fatalf("SaveTransition() error = %v", err)
+			}
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		entity := Entity{Type: "document", ID: fmt.Sprintf("doc-%d", i)}
+		es, err := storage.GetEntityState(ctx, entity)
+		if err != nil || es.State.Name != "approved" || es.Version != 3 {
+			t.Fatalf("GetEntityState(%v) = %+v, %v, want approved@3", entity, es, err)
+		}
+	}
+
+	// Returned history is a copy
+	entity := Entity{Type: "document", ID: "doc-0"}
+	history, _ := storage.GetTransitions(ctx, entity)
+	history[0].Transition.To.Name = "changed"
+	again, _ := storage.GetTransitions(ctx, entity)
+	if again[0].Transition.To.Name != "draft" {
+		t.Errorf("GetTransitions()[0] = %v after modifying a previous result, want draft", again[0].Transition.To.Name)
+	}
+}
@@ -2,6 +2,7 @@ package fsm
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -27,8 +28,12 @@ func setupTestPostgresDB(t *testing.T) *PostgresStorage {
 		t.Fatalf("Failed to create PostgreSQL storage: %v", err)
 	}
 
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
 	// Clean up the test table
-	_, err = storage.pool.Exec(ctx, "TRUNCATE TABLE entity_state_transition")
+	_, err = storage.pool.Exec(ctx, "TRUNCATE TABLE entity_state_transition, entity_current_state")
 	if err != nil {
 		t.Fatalf("Failed to clean test database: %v", err)
 	}
@@ -264,6 +269,137 @@ func TestPostgresStorage_MultipleEntities(t *testing.T) {
 	}
 }
 
+func TestPostgresStorage_CompareAndSaveTransition(t *testing.T) {
+	storage := setupTestPostgresDB(t)
+	defer storage.Close()
+
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-cas"}
+
+	start := EntityTransition{
+		Entity: entity,
+		Transition: Transition{
+			From:      State{Name: ""},
+			To:        State{Name: "draft"},
+			Event:     Event{Name: "start"},
+			CreatedBy: "user1",
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+
+	// Entity must not exist yet
+	if err := storage.CompareAndSaveTransition(ctx, EntityState{Entity: entity}, start); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	// Creating it again conflicts
+	err := storage.CompareAndSaveTransition(ctx, EntityState{Entity: entity}, start)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition() error = %v, want ErrConcurrentModification", err)
+	}
+
+	current, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+	if current.State.Name != "draft" || current.Version != 1 {
+		t.Errorf("GetEntityState() = %v@%d, want draft@1", current.State.Name, current.Version)
+	}
+
+	submit := EntityTransition{
+		Entity: entity,
+		Transition: Transition{
+			From:      State{Name: "draft"},
+			To:        State{Name: "submitted"},
+			Event:     Event{Name: "submit"},
+			CreatedBy: "user1",
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+
+	// Stale state is rejected
+	stale := EntityState{Entity: entity, State: State{Name: "submitted"}, Version: 1}
+	if err := storage.CompareAndSaveTransition(ctx, stale, submit); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale state) error = %v, want ErrConcurrentModification", err)
+	}
+
+	if err := storage.CompareAndSaveTransition(ctx, current, submit); err != nil {
+		t.Fatalf("CompareAndSaveTransition() error = %v", err)
+	}
+
+	// The same version cannot be written twice
+	if err := storage.CompareAndSaveTransition(ctx, current, submit); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("CompareAndSaveTransition(stale version) error = %v, want ErrConcurrentModification", err)
+	}
+
+	state, err := storage.GetCurrentState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetCurrentState() error = %v", err)
+	}
+	if state.Name != "submitted" {
+		t.Errorf("GetCurrentState() = %v, want submitted", state.Name)
+	}
+}
+
+func TestPostgresStorage_PayloadAndMetadata(t *testing.T) {
+	storage := setupTestPostgresDB(t)
+	defer storage.Close()
+
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-7"}
+
+	transitions := []EntityTransition{
+		{
+			Entity: entity,
+			Transition: Transition{
+				From:      State{Name: ""},
+				To:        State{Name: "submitted"},
+				Event:     Event{Name: "start"},
+				CreatedBy: "user1",
+				CreatedAt: time.Now().UTC().Add(-1 * time.Hour),
+			},
+		},
+		{
+			Entity: entity,
+			Transition: Transition{
+				From:      State{Name: "submitted"},
+				To:        State{Name: "rejected"},
+				Event:     Event{Name: "reject", Payload: map[string]any{"reason": "missing signature", "pages": 3}},
+				CreatedBy: "user2",
+				CreatedAt: time.Now().UTC(),
+				Metadata:  map[string]any{"comment": "please sign"},
+			},
+		},
+	}
+
+	for _, tr := range transitions {
+		if err := storage.SaveTransition(ctx, tr); err != nil {
+			t.Fatalf("SaveTransition() error = %v", err)
+		}
+	}
+
+	result, err := storage.GetTransitions(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetTransitions() error = %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("GetTransitions() count = %v, want 2", len(result))
+	}
+
+	if result[0].Transition.Event.Payload != nil || result[0].Transition.Metadata != nil {
+		t.Errorf("first transition payload = %v metadata = %v, want nil",
+			result[0].Transition.Event.Payload, result[0].Transition.Metadata)
+	}
+
+	payload := result[1].Transition.Event.Payload
+	if payload["reason"] != "missing signature" || payload["pages"] != float64(3) {
+		t.Errorf("event payload = %v, want reason and pages", payload)
+	}
+	if result[1].Transition.Metadata["comment"] != "please sign" {
+		t.Errorf("metadata = %v, want comment", result[1].Transition.Metadata)
+	}
+}
+
 func TestPostgresStorage_WithFSM(t *testing.T) {
 	storage := setupTestPostgresDB(t)
 	defer storage.Close()
@@ -316,3 +452,141 @@ func TestPostgresStorage_WithFSM(t *testing.T) {
 		t.Errorf("Transition history count = %v, want 2", len(history))
 	}
 }
+
+func TestPostgresStorage_WithTx(t *testing.T) {
+	storage := setupTestPostgresDB(t)
+	defer storage.Close()
+
+	states := []State{{Name: "draft"}, {Name: "submitted"}}
+	events := []Event{{Name: "submit"}}
+	transitions := []Transition{
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}},
+	}
+	fsm, err := New(states, events, transitions, storage)
+	if err != nil {
+		t.Fatalf("Failed to create FSM: %v", err)
+	}
+
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-tx"}
+	if err := fsm.Start(ctx, entity, State{Name: "draft"}, "user1"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// A rolled back transaction leaves no trace
+	tx, err := storage.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := fsm.WithStorage(storage.WithTx(tx)).Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() in tx error = %v", err)
+	}
+
+	// The pending transition is visible inside the transaction only
+	if state, _ := storage.WithTx(tx).GetCurrentState(ctx, entity); state.Name != "submitted" {
+		t.Errorf("GetCurrentState() in tx = %v, want submitted", state.Name)
+	}
+	if state, _ := fsm.GetState(ctx, entity); state.Name != "draft" {
+		t.Errorf("GetState() outside tx = %v, want draft", state.Name)
+	}
+
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if state, _ := fsm.GetState(ctx, entity); state.Name != "draft" {
+		t.Errorf("GetState() after rollback = %v, want draft", state.Name)
+	}
+
+	// A committed transaction saves the transition
+	tx, err = storage.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := fsm.WithStorage(storage.WithTx(tx)).Trigger(ctx, entity, Event{Name: "submit"}, "user1"); err != nil {
+		t.Fatalf("Trigger() in tx error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if state, _ := fsm.GetState(ctx, entity); state.Name != "submitted" {
+		t.Errorf("GetState() after commit = %v, want submitted", state.Name)
+	}
+
+	history, _ := fsm.GetTransitions(ctx, entity)
+	if len(history) != 2 {
+		t.Errorf("GetTransitions() count = %v, want 2", len(history))
+	}
+}
+
+func TestPostgresStorage_TableName(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []PostgresOption
+		want string
+	}{
+		{name: "default", want: `"entity_state_transition"`},
+		{name: "schema", opts: []PostgresOption{WithSchema("billing")}, want: `"billing"."entity_state_transition"`},
+		{name: "schema and table", opts: []PostgresOption{WithSchema("billing"), WithTable("invoice_state")}, want: `"billing"."invoice_state"`},
+		{name: "quoted", opts: []PostgresOption{WithTable(`odd"name`)}, want: `"odd""name"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewPostgresStorageFromPool(nil, tt.opts...)
+			if got := storage.tableName(); got != tt.want {
+				t.Errorf("tableName() = %v, want %v", got, tt.want)
+			}
+			if got := storage.WithTx(nil).tableName(); got != tt.want {
+				t.Errorf("WithTx().tableName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostgresStorage_CurrentStateProjection(t *testing.T) {
+	storage := setupTestPostgresDB(t)
+	defer storage.Close()
+
+	ctx := context.Background()
+	entity := Entity{Type: "document", ID: "doc-projection"}
+
+	// Transitions recorded at the same instant are still ordered
+	createdAt := time.Now().UTC()
+	for _, tr := range []Transition{
+		{To: State{Name: "draft"}, Event: Event{Name: "start"}, CreatedAt: createdAt},
+		{From: State{Name: "draft"}, To: State{Name: "submitted"}, Event: Event{Name: "submit"}, CreatedAt: createdAt},
+		{From: State{Name: "submitted"}, To: State{Name: "approved"}, Event: Event{Name: "approve"}, CreatedAt: createdAt},
+	} {
+		if err := storage.SaveTransition(ctx, EntityTransition{Entity: entity, Transition: tr}); err != nil {
+			t.Fatalf("SaveTransition() error = %v", err)
+		}
+	}
+
+	current, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() error = %v", err)
+	}
+	if current.State.Name != "approved" || current.Version != 3 {
+		t.Errorf("GetEntityState() = %v@%d, want approved@3", current.State.Name, current.Version)
+	}
+
+	// Reads come from the projection
+	if _, err := storage.pool.Exec(ctx, "DELETE FROM entity_current_state"); err != nil {
+		t.Fatalf("failed to clear projection: %v", err)
+	}
+	if _, err := storage.GetCurrentState(ctx, entity); !errors.Is(err, ErrEntityNotFound) {
+		t.Errorf("GetCurrentState() with empty projection error = %v, want ErrEntityNotFound", err)
+	}
+
+	if err := storage.RebuildCurrentState(ctx); err != nil {
+		t.Fatalf("RebuildCurrentState() error = %v", err)
+	}
+	rebuilt, err := storage.GetEntityState(ctx, entity)
+	if err != nil {
+		t.Fatalf("GetEntityState() after rebuild error = %v", err)
+	}
+	if rebuilt != current {
+		t.Errorf("GetEntityState() after rebuild = %+v, want %+v", rebuilt, current)
+	}
+}
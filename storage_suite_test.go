@@ -0,0 +1,70 @@
+package fsm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	fsm "github.com/tendant/simple-fsm"
+	"github.com/tendant/simple-fsm/fsmtest"
+)
+
+func TestMemoryStorage_Suite(t *testing.T) {
+	fsmtest.RunStorageSuite(t, func(t *testing.T) fsm.Storage {
+		return fsm.NewMemoryStorage()
+	})
+}
+
+// postgresConnString returns the connection string for testing
+// Set environment variable POSTGRES_TEST_CONN to run the suite against PostgreSQL
+func postgresConnString(t *testing.T) string {
+	connString := os.Getenv("POSTGRES_TEST_CONN")
+	if connString == "" {
+		t.Skip("Skipping PostgreSQL tests: POSTGRES_TEST_CONN not set")
+	}
+	return connString
+}
+
+func TestPostgresStorage_Suite(t *testing.T) {
+	connString := postgresConnString(t)
+
+	storage, err := fsm.NewPostgresStorage(context.Background(), connString)
+	if err != nil {
+		t.Fatalf("Failed to create PostgreSQL storage: %v", err)
+	}
+	defer storage.Close()
+
+	if err := storage.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	fsmtest.RunStorageSuite(t, func(t *testing.T) fsm.Storage {
+		return storage
+	})
+}
+
+func TestPostgresStorage_SuiteFromPool(t *testing.T) {
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, postgresConnString(t))
+	if err != nil {
+		t.Fatalf("Failed to create connection pool: %v", err)
+	}
+	defer pool.Close()
+
+	storage := fsm.NewPostgresStorageFromPool(pool, fsm.WithSchema("fsm_suite"), fsm.WithTable("transition"))
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	fsmtest.RunStorageSuite(t, func(t *testing.T) fsm.Storage {
+		return storage
+	})
+
+	// The pool belongs to the caller and stays open
+	storage.Close()
+	if err := pool.Ping(ctx); err != nil {
+		t.Errorf("Ping() after Close() error = %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+)
+
+// TransitionIterator is implemented by storage that can stream transitions
+// without loading them all into memory. Iteration stops at the first error,
+// which is yielded with a zero EntityTransition.
+type TransitionIterator interface {
+	// IterTransitions yields the history of entity in order of Sequence
+	IterTransitions(ctx context.Context, entity Entity) iter.Seq2[EntityTransition, error]
+	// IterAllTransitions yields the transitions of every entity created at
+	// or after since and before until, in order of CreatedAt. Zero values
+	// leave the range open.
+	IterAllTransitions(ctx context.Context, since, until time.Time) iter.Seq2[EntityTransition, error]
+}
+
+// IterTransitions yields the history of entity in order of Sequence:
+//
+//	for et, err := range machine.IterTransitions(ctx, entity) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// If the storage does not implement TransitionIterator, the history is loaded
+// with GetTransitions.
+func (f *FSM) IterTransitions(ctx context.Context, entity Entity) iter.Seq2[EntityTransition, error] {
+	if it, ok := f.storage.(TransitionIterator); ok {
+		return it.IterTransitions(ctx, entity)
+	}
+
+	return func(yield func(EntityTransition, error) bool) {
+		history, err := f.storage.GetTransitions(ctx, entity)
+		if err != nil {
+			yield(EntityTransition{}, err)
+			return
+		}
+		for _, et := range history {
+			if !yield(et, nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterAllTransitions yields the transitions of every entity created at or
+// after since and before until, in order of CreatedAt. Zero values leave the
+// range open. The storage must implement TransitionIterator.
+func (f *FSM) IterAllTransitions(ctx context.Context, since, until time.Time) iter.Seq2[EntityTransition, error] {
+	if it, ok := f.storage.(TransitionIterator); ok {
+		return it.IterAllTransitions(ctx, since, until)
+	}
+
+	return func(yield func(EntityTransition, error) bool) {
+		yield(EntityTransition{}, fmt.Errorf("%w: storage does not implement TransitionIterator", errors.ErrUnsupported))
+	}
+}
+
+// inTimeRange reports whether t is at or after since and before until, where
+// zero values leave the range open
+func inTimeRange(t, since, until time.Time) bool {
+	return (since.IsZero() || !t.Before(since)) && (until.IsZero() || t.Before(until))
+}
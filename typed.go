@@ -0,0 +1,237 @@
+package fsm
+
+import (
+	"context"
+	"time"
+)
+
+// Typed is an FSM whose states and events are typed string constants, so
+// that a misspelled state or event is a compile error rather than an
+// ErrInvalidState or ErrInvalidEvent at runtime:
+//
+//	type DocState string
+//	type DocEvent string
+//
+//	const (
+//		Draft     DocState = "draft"
+//		Submitted DocState = "submitted"
+//
+//		Submit DocEvent = "submit"
+//	)
+//
+//	machine, err := fsm.NewTyped(
+//		[]DocState{Draft, Submitted},
+//		[]DocEvent{Submit},
+//		[]fsm.TypedTransition[DocState, DocEvent]{{From: Draft, To: Submitted, Event: Submit}},
+//		storage,
+//		fsm.WithInitialStates(fsm.States(Draft)...),
+//	)
+//	...
+//	err = machine.Trigger(ctx, doc, Submit, "alice")
+//
+// It wraps an FSM and uses the same Storage; FSM returns the underlying FSM
+// for everything else.
+type Typed[S ~string, E ~string] struct {
+	fsm *FSM
+}
+
+// TypedTransition defines a transition of a Typed FSM
+type TypedTransition[S ~string, E ~string] struct {
+	From   S
+	To     S
+	Event  E
+	Guards []Guard
+}
+
+// NewTyped creates a Typed FSM; see New
+func NewTyped[S ~string, E ~string](states []S, events []E, transitions []TypedTransition[S, E], storage Storage, opts ...Option) (*Typed[S, E], error) {
+	ts := make([]Transition, len(transitions))
+	for i, t := range transitions {
+		ts[i] = Transition{
+			From:   State{Name: string(t.From)},
+			To:     State{Name: string(t.To)},
+			Event:  Event{Name: string(t.Event)},
+			Guards: t.Guards,
+		}
+	}
+
+	f, err := New(States(states...), Events(events...), ts, storage, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Typed[S, E]{fsm: f}, nil
+}
+
+// States converts typed state constants to States, for use with options such
+// as WithInitialStates
+func States[S ~string](states ...S) []State {
+	result := make([]State, len(states))
+	for i, s := range states {
+		result[i] = State{Name: string(s)}
+	}
+	return result
+}
+
+// Events converts typed event constants to Events
+func Events[E ~string](events ...E) []Event {
+	result := make([]Event, len(events))
+	for i, e := range events {
+		result[i] = Event{Name: string(e)}
+	}
+	return result
+}
+
+// Substates declares children as substates of the composite state parent;
+// see WithSubstates
+func Substates[S ~string](parent S, children ...S) Option {
+	return WithSubstates(State{Name: string(parent)}, States(children...)...)
+}
+
+// TypedRegion is a Region with typed states
+type TypedRegion[S ~string] struct {
+	State   S
+	Initial S
+	Final   []S
+}
+
+// TypedParallel declares state as a parallel state with the given regions,
+// joining to join once all of them are final; see WithParallel. Pass an empty
+// join for a parallel state without a join.
+func TypedParallel[S ~string](state, join S, regions ...TypedRegion[S]) Option {
+	p := Parallel{State: State{Name: string(state)}, Join: State{Name: string(join)}}
+	for _, r := range regions {
+		p.Regions = append(p.Regions, Region{
+			State:   State{Name: string(r.State)},
+			Initial: State{Name: string(r.Initial)},
+			Final:   States(r.Final...),
+		})
+	}
+	return WithParallel(p)
+}
+
+// FSM returns the underlying FSM
+func (t *Typed[S, E]) FSM() *FSM {
+	return t.fsm
+}
+
+// WithStorage returns a copy of the FSM that uses storage; see FSM.WithStorage
+func (t *Typed[S, E]) WithStorage(storage Storage) *Typed[S, E] {
+	return &Typed[S, E]{fsm: t.fsm.WithStorage(storage)}
+}
+
+// Start initializes an entity in the given state; see FSM.Start
+func (t *Typed[S, E]) Start(ctx context.Context, entity Entity, initialState S, createdBy string, opts ...TriggerOption) error {
+	return t.fsm.Start(ctx, entity, State{Name: string(initialState)}, createdBy, opts...)
+}
+
+// Reset puts an entity into the given initial state; see FSM.Reset
+func (t *Typed[S, E]) Reset(ctx context.Context, entity Entity, initialState S, createdBy string, opts ...TriggerOption) error {
+	return t.fsm.Reset(ctx, entity, State{Name: string(initialState)}, createdBy, opts...)
+}
+
+// Trigger triggers an event for an entity; see FSM.Trigger. Use WithPayload
+// to pass an event payload.
+func (t *Typed[S, E]) Trigger(ctx context.Context, entity Entity, event E, createdBy string, opts ...TriggerOption) error {
+	return t.fsm.Trigger(ctx, entity, Event{Name: string(event)}, createdBy, opts...)
+}
+
+// GetState returns the current state of an entity
+func (t *Typed[S, E]) GetState(ctx context.Context, entity Entity) (S, error) {
+	state, err := t.fsm.GetState(ctx, entity)
+	return S(state.Name), err
+}
+
+// GetStatePath returns the active state of an entity together with the
+// composite states containing it, outermost first; see FSM.GetStatePath
+func (t *Typed[S, E]) GetStatePath(ctx context.Context, entity Entity) ([]S, error) {
+	path, err := t.fsm.GetStatePath(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]S, len(path))
+	for i, s := range path {
+		result[i] = S(s.Name)
+	}
+	return result, nil
+}
+
+// GetActiveStates returns the states an entity is in; see
+// FSM.GetActiveStates
+func (t *Typed[S, E]) GetActiveStates(ctx context.Context, entity Entity) ([]S, error) {
+	active, err := t.fsm.GetActiveStates(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]S, len(active))
+	for i, s := range active {
+		result[i] = S(s.Name)
+	}
+	return result, nil
+}
+
+// IsInState reports whether the entity is in state or in a state nested in
+// it; see FSM.IsInState
+func (t *Typed[S, E]) IsInState(ctx context.Context, entity Entity, state S) (bool, error) {
+	return t.fsm.IsInState(ctx, entity, State{Name: string(state)})
+}
+
+// GetStateAt returns the state an entity was in at the given time; see
+// FSM.GetStateAt
+func (t *Typed[S, E]) GetStateAt(ctx context.Context, entity Entity, at time.Time) (S, error) {
+	state, err := t.fsm.GetStateAt(ctx, entity, at)
+	return S(state.Name), err
+}
+
+// CanTrigger checks if an event can be triggered from the entity's current
+// state; see FSM.CanTrigger
+func (t *Typed[S, E]) CanTrigger(ctx context.Context, entity Entity, event E) bool {
+	return t.fsm.CanTrigger(ctx, entity, Event{Name: string(event)})
+}
+
+// GetAvailableEvents returns the events that can be triggered from the
+// entity's current state; see FSM.GetAvailableEvents
+func (t *Typed[S, E]) GetAvailableEvents(ctx context.Context, entity Entity) ([]E, error) {
+	events, err := t.fsm.GetAvailableEvents(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]E, len(events))
+	for i, e := range events {
+		result[i] = E(e.Name)
+	}
+	return result, nil
+}
+
+// GetNextState returns the next state for a given state and event without
+// triggering; see FSM.GetNextState
+func (t *Typed[S, E]) GetNextState(currentState S, event E) (S, error) {
+	state, err := t.fsm.GetNextState(State{Name: string(currentState)}, Event{Name: string(event)})
+	return S(state.Name), err
+}
+
+// OnEnter registers a hook that runs after an entity enters state; see
+// FSM.OnEnter
+func (t *Typed[S, E]) OnEnter(state S, hook Hook) error {
+	return t.fsm.OnEnter(State{Name: string(state)}, hook)
+}
+
+// OnExit registers a hook that runs before an entity leaves state; see
+// FSM.OnExit
+func (t *Typed[S, E]) OnExit(state S, hook Hook) error {
+	return t.fsm.OnExit(State{Name: string(state)}, hook)
+}
+
+// BeforeTransition registers a hook that runs before a transition triggered
+// by event is saved; see FSM.BeforeTransition
+func (t *Typed[S, E]) BeforeTransition(event E, hook Hook) error {
+	return t.fsm.BeforeTransition(Event{Name: string(event)}, hook)
+}
+
+// AfterTransition registers a hook that runs after a transition triggered by
+// event has been saved; see FSM.AfterTransition
+func (t *Typed[S, E]) AfterTransition(event E, hook Hook) error {
+	return t.fsm.AfterTransition(Event{Name: string(event)}, hook)
+}
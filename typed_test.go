@@ -0,0 +1,247 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type orderState string
+
+type orderEvent string
+
+const (
+	orderPending   orderState = "pending"
+	orderPaid      orderState = "paid"
+	orderShipped   orderState = "shipped"
+	orderCancelled orderState = "cancelled"
+
+	orderPay    orderEvent = "pay"
+	orderShip   orderEvent = "ship"
+	orderCancel orderEvent = "cancel"
+)
+
+func newOrderFSM(t *testing.T) *Typed[orderState, orderEvent] {
+	t.Helper()
+
+	paidInFull := func(ctx context.Context, in GuardInput) error {
+		if in.Event.Payload["amount"] != 100 {
+			return errors.New("amount must be 100")
+		}
+		return nil
+	}
+
+	machine, err := NewTyped(
+		[]orderState{orderPending, orderPaid, orderShipped, orderCancelled},
+		[]orderEvent{orderPay, orderShip, orderCancel},
+		[]TypedTransition[orderState, orderEvent]{
+			{From: orderPending, To: orderPaid, Event: orderPay, Guards: []Guard{paidInFull}},
+			{From: orderPending, To: orderCancelled, Event: orderCancel},
+			{From: orderPaid, To: orderShipped, Event: orderShip},
+		},
+		NewMemoryStorage(),
+		WithInitialStates(States(orderPending)...),
+		WithTerminalStates(States(orderShipped, orderCancelled)...),
+	)
+	if err != nil {
+		t.Fatalf("NewTyped() error = %v", err)
+	}
+	return machine
+}
+
+func TestTyped(t *testing.T) {
+	machine := newOrderFSM(t)
+	ctx := context.Background()
+	order := Entity{Type: "order", ID: "order-1"}
+
+	var entered []orderState
+	mustRegister(t, machine.OnEnter(orderPaid, func(ctx context.Context, et EntityTransition) error {
+		entered = append(entered, orderState(et.Transition.To.Name))
+		return nil
+	}))
+
+	if err := machine.Start(ctx, order, orderPaid, "alice"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Start(non-initial state) error = %v, want ErrInvalidState", err)
+	}
+	if err := machine.Start(ctx, order, orderPending, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	events, err := machine.GetAvailableEvents(ctx, order)
+	if err != nil {
+		t.Fatalf("GetAvailableEvents() error = %v", err)
+	}
+	if len(events) != 1 || events[0] != orderCancel {
+		t.Errorf("GetAvailableEvents() = %v, want [cancel] (pay needs a payload)", events)
+	}
+	if !machine.CanTrigger(ctx, order, orderCancel) || machine.CanTrigger(ctx, order, orderShip) {
+		t.Error("CanTrigger() = wrong result, want cancel but not ship")
+	}
+
+	// The payload reaches guards
+	if err := machine.Trigger(ctx, order, orderPay, "alice", WithPayload(map[string]any{"amount": 50})); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Trigger(pay 50) error = %v, want a veto", err)
+	}
+	if err := machine.Trigger(ctx, order, orderPay, "alice", WithPayload(map[string]any{"amount": 100})); err != nil {
+		t.Fatalf("Trigger(pay 100) error = %v", err)
+	}
+	if err := machine.Trigger(ctx, order, orderShip, "bob"); err != nil {
+		t.Fatalf("Trigger(ship) error = %v", err)
+	}
+
+	state, err := machine.GetState(ctx, order)
+	if err != nil || state != orderShipped {
+		t.Errorf("GetState() = %q, %v, want shipped", state, err)
+	}
+	if err := machine.Trigger(ctx, order, orderCancel, "bob"); !errors.Is(err, ErrTerminalState) {
+		t.Errorf("Trigger(cancel) error = %v, want ErrTerminalState", err)
+	}
+	if len(entered) != 1 || entered[0] != orderPaid {
+		t.Errorf("OnEnter(paid) hooks ran for %v, want [paid]", entered)
+	}
+
+	history, _ := machine.FSM().GetTransitions(ctx, order)
+	if len(history) != 3 || history[1].Transition.Event.Payload["amount"] != 100 {
+		t.Errorf("GetTransitions() = %v, want start, pay with its payload, ship", history)
+	}
+
+	if state, err := machine.GetStateAt(ctx, order, time.Now()); err != nil || state != orderShipped {
+		t.Errorf("GetStateAt(now) = %q, %v, want shipped", state, err)
+	}
+}
+
+func TestTyped_GetNextState(t *testing.T) {
+	machine := newOrderFSM(t)
+
+	next, err := machine.GetNextState(orderPaid, orderShip)
+	if err != nil || next != orderShipped {
+		t.Errorf("GetNextState(paid, ship) = %q, %v, want shipped", next, err)
+	}
+	if _, err := machine.GetNextState(orderPaid, orderPay); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("GetNextState(paid, pay) error = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestNewTyped_Invalid(t *testing.T) {
+	_, err := NewTyped(
+		[]orderState{orderPending, orderPaid},
+		[]orderEvent{orderPay},
+		[]TypedTransition[orderState, orderEvent]{{From: orderPending, To: orderShipped, Event: orderPay}},
+		NewMemoryStorage(),
+	)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("NewTyped(undeclared state) error = %v, want ErrInvalidState", err)
+	}
+}
+
+func TestTyped_Substates(t *testing.T) {
+	const (
+		active  orderState = "active"
+		paused  orderState = "paused"
+		stopped orderState = "stopped"
+
+		pause orderEvent = "pause"
+		stop  orderEvent = "stop"
+	)
+
+	machine, err := NewTyped(
+		[]orderState{active, orderPending, paused, stopped},
+		[]orderEvent{pause, stop},
+		[]TypedTransition[orderState, orderEvent]{
+			{From: orderPending, To: paused, Event: pause},
+			{From: active, To: stopped, Event: stop},
+		},
+		NewMemoryStorage(),
+		Substates(active, orderPending, paused),
+	)
+	if err != nil {
+		t.Fatalf("NewTyped() error = %v", err)
+	}
+
+	ctx := context.Background()
+	order := Entity{Type: "order", ID: "order-1"}
+	if err := machine.Start(ctx, order, orderPending, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := machine.Trigger(ctx, order, pause, "alice"); err != nil {
+		t.Fatalf("Trigger(pause) error = %v", err)
+	}
+
+	path, err := machine.GetStatePath(ctx, order)
+	if err != nil || len(path) != 2 || path[0] != active || path[1] != paused {
+		t.Errorf("GetStatePath() = %v, %v, want [active paused]", path, err)
+	}
+	if in, err := machine.IsInState(ctx, order, active); err != nil || !in {
+		t.Errorf("IsInState(active) = %v, %v, want true", in, err)
+	}
+
+	if err := machine.Trigger(ctx, order, stop, "alice"); err != nil {
+		t.Fatalf("Trigger(stop) error = %v", err)
+	}
+	if state, err := machine.GetState(ctx, order); err != nil || state != stopped {
+		t.Errorf("GetState() = %q, %v, want stopped", state, err)
+	}
+}
+
+func TestTyped_Parallel(t *testing.T) {
+	type loanState string
+	const (
+		draft      loanState = "draft"
+		review     loanState = "review"
+		documents  loanState = "documents"
+		collecting loanState = "collecting"
+		verified   loanState = "verified"
+		credit     loanState = "credit"
+		checking   loanState = "checking"
+		passed     loanState = "passed"
+		approved   loanState = "approved"
+
+		submit orderEvent = "submit"
+		verify orderEvent = "verify"
+		pass   orderEvent = "pass"
+	)
+
+	machine, err := NewTyped(
+		[]loanState{draft, review, documents, collecting, verified, credit, checking, passed, approved},
+		[]orderEvent{submit, verify, pass},
+		[]TypedTransition[loanState, orderEvent]{
+			{From: draft, To: review, Event: submit},
+			{From: collecting, To: verified, Event: verify},
+			{From: checking, To: passed, Event: pass},
+		},
+		NewMemoryStorage(),
+		Substates(documents, collecting, verified),
+		Substates(credit, checking, passed),
+		TypedParallel(review, approved,
+			TypedRegion[loanState]{State: documents, Initial: collecting, Final: []loanState{verified}},
+			TypedRegion[loanState]{State: credit, Initial: checking, Final: []loanState{passed}},
+		),
+	)
+	if err != nil {
+		t.Fatalf("NewTyped() error = %v", err)
+	}
+
+	ctx := context.Background()
+	loan := Entity{Type: "loan", ID: "loan-1"}
+	if err := machine.Start(ctx, loan, draft, "alice"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, event := range []orderEvent{submit, verify} {
+		if err := machine.Trigger(ctx, loan, event, "alice"); err != nil {
+			t.Fatalf("Trigger(%s) error = %v", event, err)
+		}
+	}
+
+	active, err := machine.GetActiveStates(ctx, loan)
+	if err != nil || len(active) != 2 || active[0] != verified || active[1] != checking {
+		t.Errorf("GetActiveStates() = %v, %v, want [verified checking]", active, err)
+	}
+
+	if err := machine.Trigger(ctx, loan, pass, "alice"); err != nil {
+		t.Fatalf("Trigger(pass) error = %v", err)
+	}
+	if state, err := machine.GetState(ctx, loan); err != nil || state != approved {
+		t.Errorf("GetState() = %q, %v, want approved", state, err)
+	}
+}